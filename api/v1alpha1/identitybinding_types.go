@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IdentityReference points at a UserAssignedIdentity in either scope.
+type IdentityReference struct {
+	// Name of the UserAssignedIdentity object.
+	Name string `json:"name"`
+
+	// Namespace of a namespaced (managedidentity.azure.m.upbound.io) identity.
+	// Leave empty to reference a cluster-scoped (managedidentity.azure.upbound.io) identity.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ServiceAccountReference points at a single ServiceAccount.
+type ServiceAccountReference struct {
+	// Name of the ServiceAccount.
+	Name string `json:"name"`
+
+	// Namespace of the ServiceAccount.
+	Namespace string `json:"namespace"`
+}
+
+// IdentityBindingSpec defines the desired state of IdentityBinding
+type IdentityBindingSpec struct {
+	// IdentityRef references the UserAssignedIdentity whose client and principal IDs are propagated.
+	IdentityRef IdentityReference `json:"identityRef"`
+
+	// ServiceAccounts to annotate with the identity's client ID.
+	// +optional
+	ServiceAccounts []ServiceAccountReference `json:"serviceAccounts,omitempty"`
+
+	// ServiceAccountSelector selects additional ServiceAccounts by label in all namespaces.
+	// +optional
+	ServiceAccountSelector *metav1.LabelSelector `json:"serviceAccountSelector,omitempty"`
+
+	// RoleAssignmentSelector selects the RoleAssignments, in both scopes, whose principal ID
+	// is kept in sync with the identity. RoleAssignments are left alone when unset.
+	// +optional
+	RoleAssignmentSelector *metav1.LabelSelector `json:"roleAssignmentSelector,omitempty"`
+}
+
+// IdentityBindingStatus defines the observed state of IdentityBinding
+type IdentityBindingStatus struct {
+	// ObservedGeneration is the most recent generation reconciled.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ClientID last propagated to the bound ServiceAccounts.
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+
+	// PrincipalID last propagated to the selected RoleAssignments.
+	// +optional
+	PrincipalID string `json:"principalID,omitempty"`
+
+	// Conditions describe the current state of the binding.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="IDENTITY",type="string",JSONPath=".spec.identityRef.name"
+// +kubebuilder:printcolumn:name="CLIENT-ID",type="string",JSONPath=".status.clientID"
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// IdentityBinding is the Schema for the identitybindings API. It explicitly binds a
+// UserAssignedIdentity to the ServiceAccounts and RoleAssignments that depend on it.
+type IdentityBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdentityBindingSpec   `json:"spec,omitempty"`
+	Status IdentityBindingStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IdentityBindingList contains a list of IdentityBinding
+type IdentityBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdentityBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdentityBinding{}, &IdentityBindingList{})
+}
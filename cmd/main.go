@@ -19,10 +19,14 @@ package main
 import (
 	"crypto/tls"
 	"flag"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
 	"github.com/fortytwoservices/clientid-operator/controllers"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
@@ -30,14 +34,19 @@ import (
 	clustermanagedidentityv1beta1 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
 	namespacedauthorizationv1beta1 "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
 	namespacedmanagedidentityv1beta1 "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,18 +61,48 @@ func init() {
 	utilruntime.Must(namespacedauthorizationv1beta1.AddToScheme(scheme))
 	utilruntime.Must(clustermanagedidentityv1beta1.AddToScheme(scheme))
 	utilruntime.Must(clusterauthorizationv1beta1.AddToScheme(scheme))
+	utilruntime.Must(identityv1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(runReport(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var introspectionAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var enableIdentityBindings bool
+	var workloadKinds string
+	var restartPolicy string
+	var restartStrategy string
+	var restartStalePodsOnly bool
+	var pauseRestartsOnRolloutFailure bool
+	var maintenanceWindow string
+	var maintenanceWindowDuration time.Duration
+	var watchNamespaces string
+	var excludeNamespaces string
+	var namespaceSelector string
+	var resyncPeriod time.Duration
+	var cleanupPolicy string
+	var dryRun bool
+	var propagateTenantID bool
+	var tokenExpiration time.Duration
+	var useLabel bool
+	var oidcIssuerURL string
+	var namingValidation string
+	var injectClientID bool
+	var roleAssignmentScope string
+	appNameConfig := controllers.DefaultAppNameConfig()
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&introspectionAddr, "introspection-bind-address", "0",
+		"The address the read-only /identities endpoint binds to. Set to 0 to disable it.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -71,6 +110,69 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&enableIdentityBindings, "enable-identity-bindings", false,
+		"If set, IdentityBinding resources are reconciled and identities they reference "+
+			"are no longer matched by naming convention. Ignored when the IdentityBinding CRD is not installed.")
+	bindAppNameFlags(flag.CommandLine, &appNameConfig)
+	flag.StringVar(&workloadKinds, "restart-workload-kinds", strings.Join(controllers.DefaultWorkloadKinds, ","),
+		"Comma-separated workload kinds restarted after a ServiceAccount's client ID changes: "+
+			"Deployment, StatefulSet, DaemonSet, CronJob and Rollout (Argo Rollouts, requires the Rollout CRD).")
+	flag.StringVar(&restartPolicy, "restart-policy", controllers.RestartPolicyImmediate,
+		"When workloads are restarted after a client ID change: never, immediate, staggered (one workload at a time, "+
+			"waiting for each rollout) or maintenance-window. ServiceAccounts can override it with the "+
+			"clientid-operator/restart-policy annotation.")
+	flag.StringVar(&restartStrategy, "restart-strategy", controllers.RestartStrategyTemplateAnnotation,
+		"How workloads are restarted: template-annotation (azure.workload.identity/restart), restarted-at "+
+			"(kubectl.kubernetes.io/restartedAt) or evict (Eviction API, respecting PodDisruptionBudgets). "+
+			"Workloads can override it with the clientid-operator/restart-strategy annotation.")
+	flag.BoolVar(&pauseRestartsOnRolloutFailure, "pause-restarts-on-rollout-failure", false,
+		"Pause the workload restarts of an identity when a rollout it triggered fails, until the "+
+			"clientid-operator/restarts-paused annotation is removed from the identity.")
+	flag.BoolVar(&restartStalePodsOnly, "restart-stale-pods-only", false,
+		"Only restart workloads with running pods whose AZURE_CLIENT_ID differs from the ServiceAccount's client ID. "+
+			"Caches all Pods and exports clientid_operator_stale_pods.")
+	flag.StringVar(&maintenanceWindow, "maintenance-window", "",
+		"Cron schedule in UTC at which the maintenance window opens, e.g. \"0 2 * * 6\" for Saturdays at 02:00.")
+	flag.DurationVar(&maintenanceWindowDuration, "maintenance-window-duration", time.Hour,
+		"How long the maintenance window stays open.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated namespaces searched for ServiceAccounts and cached. Defaults to all namespaces.")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", "",
+		"Comma-separated namespaces never searched for ServiceAccounts nor cached.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector restricting the namespaces searched for ServiceAccounts, e.g. clientid-operator/enabled=true. "+
+			"Requires read access to Namespaces, granted by the namespace-selector kustomize component.")
+	flag.StringVar(&roleAssignmentScope, "role-assignment-scope", controllers.RoleAssignmentScopeIdentity,
+		"Which RoleAssignments of its app an identity is wired to: identity (namespaced identities to namespaced "+
+			"RoleAssignments in their own namespace, cluster-scoped identities to RoleAssignments of both scopes) "+
+			"or cluster (every identity to RoleAssignments of both scopes in all namespaces).")
+	flag.StringVar(&cleanupPolicy, "cleanup-policy", controllers.CleanupPolicyOrphan,
+		"What happens to the ServiceAccounts and RoleAssignments of a deleted identity: orphan (left as they are), "+
+			"clear (client ID annotation removed, RoleAssignments paused) or delete (client ID annotation removed, "+
+			"RoleAssignments deleted). Identities can override it with the clientid-operator/cleanup-policy annotation.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Compute and log every ServiceAccount, RoleAssignment and workload change without making it. "+
+			"Events are still recorded, prefixed with [dry-run].")
+	flag.BoolVar(&propagateTenantID, "propagate-tenant-id", false,
+		"Set the azure.workload.identity/tenant-id annotation on ServiceAccounts to the identity's tenant ID.")
+	flag.DurationVar(&tokenExpiration, "token-expiration", 0,
+		"Set the azure.workload.identity/service-account-token-expiration annotation on ServiceAccounts, "+
+			"between 1h and 24h. 0 leaves it unset.")
+	flag.BoolVar(&useLabel, "ensure-use-label", false,
+		"Add the azure.workload.identity/use: \"true\" label to the pod templates of the workloads using the "+
+			"ServiceAccounts. Adding the label rolls the workload out.")
+	flag.StringVar(&oidcIssuerURL, "oidc-issuer-url", "",
+		"The OIDC issuer URL of the cluster. When set, a FederatedIdentityCredential is managed for every "+
+			"ServiceAccount of an identity.")
+	flag.StringVar(&namingValidation, "naming-validation", controllers.NamingValidationOff,
+		"Serve a validating webhook at "+controllers.NamingWebhookPath+" checking UserAssignedIdentities, "+
+			"ServiceAccounts and RoleAssignments against the naming convention: off, warn (admit with a warning) or deny.")
+	flag.BoolVar(&injectClientID, "inject-client-id", false,
+		"Serve a mutating webhook at "+controllers.ServiceAccountWebhookPath+" setting the workload identity "+
+			"annotations on ServiceAccounts when they are created, so pods start with the right client ID. Updates are "+
+			"left to the reconciler, which restarts the workloads when the client ID changes.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"How often identities that are in sync are reconciled again to catch missed events. 0 disables the resync.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -79,6 +181,63 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	appNames, err := controllers.NewAppNameExtractor(appNameConfig)
+	if err != nil {
+		setupLog.Error(err, "Invalid app name configuration")
+		os.Exit(1)
+	}
+
+	// An empty list disables restarts
+	restartKinds := splitList(workloadKinds)
+	if err := controllers.ValidateWorkloadKinds(restartKinds); err != nil {
+		setupLog.Error(err, "Invalid workload kinds")
+		os.Exit(1)
+	}
+
+	var window *controllers.MaintenanceWindow
+	if maintenanceWindow != "" {
+		if window, err = controllers.ParseMaintenanceWindow(maintenanceWindow, maintenanceWindowDuration); err != nil {
+			setupLog.Error(err, "Invalid maintenance window")
+			os.Exit(1)
+		}
+	}
+	if err := controllers.ValidateRestartPolicy(restartPolicy, window); err != nil {
+		setupLog.Error(err, "Invalid restart policy")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateRestartStrategy(restartStrategy); err != nil {
+		setupLog.Error(err, "Invalid restart strategy")
+		os.Exit(1)
+	}
+
+	if err := controllers.ValidateTokenExpiration(tokenExpiration); err != nil {
+		setupLog.Error(err, "Invalid token expiration")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateCleanupPolicy(cleanupPolicy); err != nil {
+		setupLog.Error(err, "Invalid cleanup policy")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateRoleAssignmentScope(roleAssignmentScope); err != nil {
+		setupLog.Error(err, "Invalid RoleAssignment scope")
+		os.Exit(1)
+	}
+	if err := controllers.ValidateNamingValidation(namingValidation); err != nil {
+		setupLog.Error(err, "Invalid naming validation mode")
+		os.Exit(1)
+	}
+
+	namespaces := controllers.NamespaceScope{
+		Watch:   splitList(watchNamespaces),
+		Exclude: splitList(excludeNamespaces),
+	}
+	if namespaceSelector != "" {
+		if namespaces.Selector, err = labels.Parse(namespaceSelector); err != nil {
+			setupLog.Error(err, "Invalid namespace selector")
+			os.Exit(1)
+		}
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancelation and
@@ -101,6 +260,9 @@ func main() {
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Cache: cache.Options{
+			ByObject: namespaces.ByObject(restartKinds),
+		},
 		Metrics: metricsserver.Options{
 			BindAddress:   metricsAddr,
 			SecureServing: secureMetrics,
@@ -127,17 +289,98 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := (&controllers.UserAssignedIdentityReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    ctrl.Log.WithName("controllers").WithName("UserAssignedIdentity"),
-	}).SetupWithManager(mgr); err != nil {
+	if enableIdentityBindings {
+		gvk := identityv1alpha1.GroupVersion.WithKind("IdentityBinding")
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			if !meta.IsNoMatchError(err) {
+				setupLog.Error(err, "Unable to look up the IdentityBinding CRD")
+				os.Exit(1)
+			}
+			setupLog.Info("IdentityBinding CRD not installed, disabling IdentityBindings")
+			enableIdentityBindings = false
+		}
+	}
+
+	k8sClient := mgr.GetClient()
+	recorder := mgr.GetEventRecorderFor("clientid-operator")
+	if dryRun {
+		setupLog.Info("Running in dry-run mode, no changes will be made")
+		k8sClient = controllers.NewDryRunClient(k8sClient, ctrl.Log.WithName("dry-run"))
+		recorder = controllers.NewDryRunRecorder(recorder)
+	}
+
+	identityReconciler := &controllers.UserAssignedIdentityReconciler{
+		Client:                        k8sClient,
+		Scheme:                        mgr.GetScheme(),
+		Log:                           ctrl.Log.WithName("controllers").WithName("UserAssignedIdentity"),
+		AppNames:                      appNames,
+		IdentityBindings:              enableIdentityBindings,
+		Recorder:                      recorder,
+		WorkloadKinds:                 restartKinds,
+		RestartPolicy:                 restartPolicy,
+		RestartStrategy:               restartStrategy,
+		RestartStalePodsOnly:          restartStalePodsOnly,
+		PauseRestartsOnRolloutFailure: pauseRestartsOnRolloutFailure,
+		MaintenanceWindow:             window,
+		Namespaces:                    namespaces,
+		ResyncPeriod:                  resyncPeriod,
+		CleanupPolicy:                 cleanupPolicy,
+		PropagateTenantID:             propagateTenantID,
+		TokenExpiration:               tokenExpiration,
+		UseLabel:                      useLabel,
+		OIDCIssuerURL:                 oidcIssuerURL,
+		RoleAssignmentScope:           roleAssignmentScope,
+		DryRun:                        dryRun,
+	}
+	if err := identityReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "Unable to create controller", "controller", "UserAssignedIdentity")
 		os.Exit(1)
 	}
 
+	if namingValidation != controllers.NamingValidationOff {
+		(&controllers.NamingValidator{
+			Decoder:    admission.NewDecoder(mgr.GetScheme()),
+			Identities: identityReconciler,
+			Deny:       namingValidation == controllers.NamingValidationDeny,
+		}).SetupWebhookWithManager(mgr)
+	}
+
+	if injectClientID {
+		(&controllers.ServiceAccountInjector{
+			Decoder:    admission.NewDecoder(mgr.GetScheme()),
+			Identities: identityReconciler,
+			DryRun:     dryRun,
+		}).SetupWebhookWithManager(mgr)
+	}
+
+	if enableIdentityBindings {
+		if err := (&controllers.IdentityBindingReconciler{
+			Client:     k8sClient,
+			Scheme:     mgr.GetScheme(),
+			Log:        ctrl.Log.WithName("controllers").WithName("IdentityBinding"),
+			Identities: identityReconciler,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "Unable to create controller", "controller", "IdentityBinding")
+			os.Exit(1)
+		}
+	}
+
 	//+kubebuilder:scaffold:builder
 
+	if introspectionAddr != "0" {
+		if err := mgr.Add(&manager.Server{
+			Name: "introspection",
+			Server: &http.Server{
+				Addr:              introspectionAddr,
+				Handler:           controllers.NewIntrospectionHandler(identityReconciler),
+				ReadHeaderTimeout: 10 * time.Second,
+			},
+		}); err != nil {
+			setupLog.Error(err, "Unable to set up the introspection endpoint")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "Unable to set up health check")
 		os.Exit(1)
@@ -153,3 +396,32 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries. An empty
+// value yields an empty, non-nil list.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// bindAppNameFlags registers the flags configuring how app names are derived on fs.
+func bindAppNameFlags(fs *flag.FlagSet, cfg *controllers.AppNameConfig) {
+	fs.StringVar(&cfg.Strategy, "app-name-strategy", cfg.Strategy,
+		"How the app name is derived from a UserAssignedIdentity: segment, regex, label or annotation.")
+	fs.StringVar(&cfg.Source, "app-name-source", cfg.Source,
+		"Name parsed by the segment and regex strategies: for-provider (spec.forProvider.name) "+
+			"or external-name (the crossplane.io/external-name annotation).")
+	fs.IntVar(&cfg.Segment, "app-name-segment", cfg.Segment,
+		"Zero-based index of the dash-separated name segment holding the app name (segment strategy).")
+	fs.IntVar(&cfg.Segments, "app-name-segments", cfg.Segments,
+		"Number of dash-separated segments a name must have (segment strategy). 0 accepts any name with the app name segment.")
+	fs.StringVar(&cfg.Regex, "app-name-regex", cfg.Regex,
+		"Regular expression with a named capture group `app` (regex strategy).")
+	fs.StringVar(&cfg.Key, "app-name-key", cfg.Key,
+		"Label or annotation on the UserAssignedIdentity holding the app name (label and annotation strategies).")
+}
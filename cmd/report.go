@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fortytwoservices/clientid-operator/controllers"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// runReport implements `clientid-operator report`, which prints the identities of the cluster
+// with their ServiceAccounts, workloads and RoleAssignments, and returns the exit code.
+// Flags are parsed into the default flag set, so --kubeconfig works as for the manager.
+func runReport(args []string) int {
+	var format string
+	var workloadKinds string
+	var roleAssignmentScope string
+	appNameConfig := controllers.DefaultAppNameConfig()
+	flag.StringVar(&format, "output", controllers.ReportFormatTable, "Output format: table, json, mermaid or dot.")
+	flag.StringVar(&workloadKinds, "restart-workload-kinds", strings.Join(controllers.DefaultWorkloadKinds, ","),
+		"Comma-separated workload kinds listed as using the ServiceAccounts.")
+	flag.StringVar(&roleAssignmentScope, "role-assignment-scope", controllers.RoleAssignmentScopeIdentity,
+		"Which RoleAssignments of its app an identity is wired to: identity or cluster.")
+	bindAppNameFlags(flag.CommandLine, &appNameConfig)
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s report [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	appNames, err := controllers.NewAppNameExtractor(appNameConfig)
+	if err != nil {
+		setupLog.Error(err, "Invalid app name configuration")
+		return 1
+	}
+	kinds := splitList(workloadKinds)
+	if err := controllers.ValidateWorkloadKinds(kinds); err != nil {
+		setupLog.Error(err, "Invalid workload kinds")
+		return 1
+	}
+	if err := controllers.ValidateRoleAssignmentScope(roleAssignmentScope); err != nil {
+		setupLog.Error(err, "Invalid RoleAssignment scope")
+		return 1
+	}
+	if err := controllers.ValidateReportFormat(format); err != nil {
+		setupLog.Error(err, "Invalid output format")
+		return 1
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		setupLog.Error(err, "Unable to load the kubeconfig")
+		return 1
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "Unable to create a client")
+		return 1
+	}
+
+	reports, err := (&controllers.UserAssignedIdentityReconciler{
+		Client:              c,
+		Scheme:              scheme,
+		Log:                 ctrl.Log.WithName("report"),
+		AppNames:            appNames,
+		WorkloadKinds:       kinds,
+		RoleAssignmentScope: roleAssignmentScope,
+	}).Inventory(context.Background())
+	if err != nil {
+		setupLog.Error(err, "Unable to list identities")
+		return 1
+	}
+	if err := controllers.WriteReport(os.Stdout, reports, format); err != nil {
+		setupLog.Error(err, "Unable to write the report")
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FieldManager is the field manager the operator applies its fields with, so GitOps tools
+// and Crossplane can tell them apart from their own.
+const FieldManager = "clientid-operator"
+
+// apply server-side applies the fields at paths of obj, built by config, as FieldManager.
+// A conflict with fields another manager last wrote through an update, such as kubectl edit,
+// a controller or the operator's own writes from before it used server-side apply, is
+// resolved by taking those fields over, as controllers are expected to. Fields another
+// manager declared, by applying them server-side or client-side as GitOps tools do, are never
+// taken over: the conflict is recorded as an Event on owner and returned, so the identity
+// is requeued until the other manager gives them up. The managers are classified from the
+// managedFields of obj read after the conflict, and the forced apply is conditional on
+// that read, so it is retried should they change in between.
+func (r *UserAssignedIdentityReconciler) apply(ctx context.Context, obj client.Object, paths [][]string, owner client.Object, config func(resourceVersion string) runtime.ApplyConfiguration) error {
+	var declared error
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Apply(ctx, config(""), client.FieldOwner(FieldManager))
+		if !errors.IsConflict(err) || !errors.HasStatusCause(err, metav1.CauseTypeFieldManagerConflict) {
+			return err
+		}
+		latest := obj.DeepCopyObject().(client.Object)
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), latest); err != nil {
+			return err
+		}
+		if manager := declaringManager(latest, paths); manager != "" {
+			r.event(owner, corev1.EventTypeWarning, "FieldConflict", fmt.Sprintf("Fields are declared by %s, not taking them over: %v", manager, err))
+			declared = err
+			return nil
+		}
+		return r.Apply(ctx, config(latest.GetResourceVersion()), client.FieldOwner(FieldManager), client.ForceOwnership)
+	})
+	if err != nil {
+		return err
+	}
+	return declared
+}
+
+// declaringManager returns the manager other than FieldManager that declared one of the fields
+// at paths of obj, or "" if they were only written through updates. A manager declares a
+// field by applying it, recorded as an Apply operation in managedFields, or by updating it
+// with a client-side apply, which also records it in the last-applied-configuration annotation.
+func declaringManager(obj client.Object, paths [][]string) string {
+	var lastApplied map[string]any
+	_ = json.Unmarshal([]byte(obj.GetAnnotations()[corev1.LastAppliedConfigAnnotation]), &lastApplied)
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == FieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		var owned map[string]any
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &owned); err != nil {
+			continue
+		}
+		for _, path := range paths {
+			if !hasField(owned, "f:", path) {
+				continue
+			}
+			if entry.Operation == metav1.ManagedFieldsOperationApply || hasField(lastApplied, "", path) {
+				return entry.Manager
+			}
+		}
+	}
+	return ""
+}
+
+// hasField reports whether the nested map fields contains path, each key of which is prefixed
+// with prefix, "f:" in managedFields.
+func hasField(fields map[string]any, prefix string, path []string) bool {
+	for _, key := range path {
+		value, ok := fields[prefix+key]
+		if !ok {
+			return false
+		}
+		fields, _ = value.(map[string]any)
+	}
+	return true
+}
+
+// applyServiceAccountAnnotations applies the workload identity annotations of sa, the only
+// ServiceAccount fields the operator owns. Annotations it applied before and that are
+// missing from annotations are removed. The apply carries the UID of sa, so the apiserver
+// rejects it instead of re-creating a ServiceAccount deleted in the meantime.
+func (r *UserAssignedIdentityReconciler) applyServiceAccountAnnotations(ctx context.Context, sa *corev1.ServiceAccount, annotations map[string]string) error {
+	var paths [][]string
+	for key := range annotations {
+		paths = append(paths, []string{"metadata", "annotations", key})
+	}
+	return r.apply(ctx, sa, paths, sa, func(resourceVersion string) runtime.ApplyConfiguration {
+		config := corev1ac.ServiceAccount(sa.Name, sa.Namespace).WithUID(sa.UID).WithAnnotations(annotations)
+		if resourceVersion != "" {
+			config.WithResourceVersion(resourceVersion)
+		}
+		return config
+	})
+}
+
+// applyPrincipalID applies spec.forProvider.principalId of a RoleAssignment of either scope,
+// the only RoleAssignment field the operator owns. Like for ServiceAccounts, the apply
+// carries the UID of roleAssignment.
+func (r *UserAssignedIdentityReconciler) applyPrincipalID(ctx context.Context, roleAssignment client.Object, principalID string) error {
+	gvk, err := r.GroupVersionKindFor(roleAssignment)
+	if err != nil {
+		return err
+	}
+	path := []string{"spec", "forProvider", "principalId"}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	u.SetName(roleAssignment.GetName())
+	u.SetNamespace(roleAssignment.GetNamespace())
+	u.SetUID(roleAssignment.GetUID())
+	if err := unstructured.SetNestedField(u.Object, principalID, path...); err != nil {
+		return err
+	}
+	return r.apply(ctx, roleAssignment, [][]string{path}, roleAssignment, func(resourceVersion string) runtime.ApplyConfiguration {
+		config := u.DeepCopy()
+		config.SetResourceVersion(resourceVersion)
+		return client.ApplyConfigurationFromUnstructured(config)
+	})
+}
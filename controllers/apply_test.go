@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// managers returns the field managers recorded on obj.
+func managers(obj client.Object) []string {
+	var names []string
+	for _, entry := range obj.GetManagedFields() {
+		names = append(names, entry.Manager)
+	}
+	return names
+}
+
+func TestApplyServiceAccountAnnotations(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+
+	clientSideApplied, _ := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{clientIDAnnotation: "stale-client-id"}}})
+	tests := []struct {
+		name string
+		// write sets the client ID annotation of sa to stale-client-id as another manager
+		write    func(ctx context.Context, cl client.Client, sa *corev1.ServiceAccount) error
+		takeOver bool
+	}{
+		{
+			name: "updated by kubectl edit",
+			write: func(ctx context.Context, cl client.Client, sa *corev1.ServiceAccount) error {
+				sa.Annotations[clientIDAnnotation] = "stale-client-id"
+				return cl.Update(ctx, sa, client.FieldOwner("kubectl-edit"))
+			},
+			takeOver: true,
+		},
+		{
+			name: "applied server-side by a GitOps tool",
+			write: func(ctx context.Context, cl client.Client, sa *corev1.ServiceAccount) error {
+				gitops := corev1ac.ServiceAccount(sa.Name, sa.Namespace).
+					WithAnnotations(map[string]string{clientIDAnnotation: "stale-client-id"})
+				return cl.Apply(ctx, gitops, client.FieldOwner("argocd"), client.ForceOwnership)
+			},
+		},
+		{
+			name: "applied client-side by a GitOps tool",
+			write: func(ctx context.Context, cl client.Client, sa *corev1.ServiceAccount) error {
+				sa.Annotations[clientIDAnnotation] = "stale-client-id"
+				sa.Annotations[corev1.LastAppliedConfigAnnotation] = string(clientSideApplied)
+				return cl.Update(ctx, sa, client.FieldOwner("argocd-controller"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name: "workload-identity-testapp", Namespace: "default", Annotations: map[string]string{"team": "payments"},
+			}}
+			cl := fake.NewClientBuilder().WithScheme(s).WithObjects(sa).WithReturnManagedFields().Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), Recorder: recorder}
+			ctx := context.Background()
+			key := types.NamespacedName{Name: sa.Name, Namespace: sa.Namespace}
+			current := func() *corev1.ServiceAccount {
+				t.Helper()
+				var current corev1.ServiceAccount
+				if err := cl.Get(ctx, key, &current); err != nil {
+					t.Fatalf("Failed to get ServiceAccount: %v", err)
+				}
+				return &current
+			}
+
+			// The operator applied the client ID before
+			if err := r.applyServiceAccountAnnotations(ctx, current(), map[string]string{clientIDAnnotation: "old-client-id"}); err != nil {
+				t.Fatalf("applyServiceAccountAnnotations() error = %v", err)
+			}
+			if err := tt.write(ctx, cl, current()); err != nil {
+				t.Fatalf("Failed to write the annotation: %v", err)
+			}
+
+			err := r.applyServiceAccountAnnotations(ctx, current(), map[string]string{clientIDAnnotation: "test-client-id"})
+			got := current()
+			if tt.takeOver {
+				if err != nil {
+					t.Fatalf("applyServiceAccountAnnotations() error = %v", err)
+				}
+				if got.Annotations[clientIDAnnotation] != "test-client-id" || got.Annotations["team"] != "payments" {
+					t.Errorf("Expected the client ID to be taken over and the other annotations kept, got %v", got.Annotations)
+				}
+				if !slices.Contains(managers(got), FieldManager) {
+					t.Errorf("Expected %s among the field managers, got %v", FieldManager, managers(got))
+				}
+				if len(recorder.Events) > 0 {
+					t.Errorf("Unexpected event %q", <-recorder.Events)
+				}
+				return
+			}
+			if !errors.IsConflict(err) {
+				t.Fatalf("Expected a conflict, got %v", err)
+			}
+			if got := got.Annotations[clientIDAnnotation]; got != "stale-client-id" {
+				t.Errorf("Expected the declared client ID to be kept, got %s", got)
+			}
+			if len(recorder.Events) != 1 {
+				t.Fatalf("Expected 1 event, got %d", len(recorder.Events))
+			}
+			if event := <-recorder.Events; !strings.HasPrefix(event, "Warning FieldConflict") {
+				t.Errorf("Unexpected event %q", event)
+			}
+		})
+	}
+}
+
+// TestApply_UID checks that applies carry the UID of the object read, which makes the
+// apiserver reject them instead of creating a stub when the object was deleted since.
+func TestApply_UID(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: "default", UID: "sa-uid"}}
+	roleAssignment := &ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp", Namespace: "default", UID: "ra-uid"}}
+	var uids []string
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(sa, roleAssignment).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(ctx context.Context, c client.WithWatch, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+			data, err := json.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			var applied metav1.PartialObjectMetadata
+			if err := json.Unmarshal(data, &applied); err != nil {
+				return err
+			}
+			uids = append(uids, string(applied.UID))
+			return nil
+		},
+	}).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+	ctx := context.Background()
+
+	if err := r.applyServiceAccountAnnotations(ctx, sa, map[string]string{clientIDAnnotation: "test-client-id"}); err != nil {
+		t.Fatalf("applyServiceAccountAnnotations() error = %v", err)
+	}
+	if err := r.applyPrincipalID(ctx, roleAssignment, "test-principal-id"); err != nil {
+		t.Fatalf("applyPrincipalID() error = %v", err)
+	}
+	if !slices.Equal(uids, []string{"sa-uid", "ra-uid"}) {
+		t.Errorf("Expected the applies to carry the UIDs read, got %v", uids)
+	}
+}
+
+// TestApply_RetryOnConflict checks that a forced apply rejected because the object changed
+// since its managers were classified is retried from the start.
+func TestApply_RetryOnConflict(t *testing.T) {
+	s := scheme.Scheme
+	_ = ra.AddToScheme(s)
+
+	roleAssignment := &ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp", Namespace: "default"}}
+	var applies []string
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(roleAssignment).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(ctx context.Context, c client.WithWatch, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+			applyOpts := &client.ApplyOptions{}
+			applyOpts.ApplyOptions(opts)
+			if !ptr.Deref(applyOpts.Force, false) {
+				applies = append(applies, "apply")
+				return errors.NewApplyConflict([]metav1.StatusCause{{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.forProvider.principalId"}}, "conflict")
+			}
+			applies = append(applies, "force")
+			if len(applies) == 2 {
+				return errors.NewConflict(ra.CRDGroupVersion.WithResource("roleassignments").GroupResource(), roleAssignment.Name, nil)
+			}
+			return c.Apply(ctx, obj, opts...)
+		},
+	}).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+
+	if err := r.applyPrincipalID(context.Background(), roleAssignment, "test-principal-id"); err != nil {
+		t.Fatalf("applyPrincipalID() error = %v", err)
+	}
+	if !slices.Equal(applies, []string{"apply", "force", "apply", "force"}) {
+		t.Errorf("Expected the apply to be retried after the conflict, got %v", applies)
+	}
+}
+
+func TestApplyPrincipalID(t *testing.T) {
+	s := scheme.Scheme
+	_ = ra.AddToScheme(s)
+	_ = ra2.AddToScheme(s)
+
+	namespaced := &ra.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp", Namespace: "default"},
+		Spec: ra.RoleAssignmentSpec{ForProvider: ra.RoleAssignmentParameters{
+			PrincipalID:        ptr.To("old-principal-id"),
+			RoleDefinitionName: ptr.To("Reader"),
+		}},
+	}
+	cluster := &ra2.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp-cluster"},
+		Spec:       ra2.RoleAssignmentSpec{ForProvider: ra2.RoleAssignmentParameters{RoleDefinitionName: ptr.To("Reader")}},
+	}
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(namespaced, cluster).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+	ctx := context.Background()
+
+	if err := r.applyPrincipalID(ctx, namespaced, "test-principal-id"); err != nil {
+		t.Fatalf("applyPrincipalID() error = %v", err)
+	}
+	if err := r.applyPrincipalID(ctx, cluster, "test-principal-id"); err != nil {
+		t.Fatalf("applyPrincipalID() error = %v", err)
+	}
+
+	var gotNamespaced ra.RoleAssignment
+	_ = cl.Get(ctx, client.ObjectKeyFromObject(namespaced), &gotNamespaced)
+	if got := ptr.Deref(gotNamespaced.Spec.ForProvider.PrincipalID, ""); got != "test-principal-id" {
+		t.Errorf("PrincipalID incorrect. Expected test-principal-id, got %s", got)
+	}
+	if got := ptr.Deref(gotNamespaced.Spec.ForProvider.RoleDefinitionName, ""); got != "Reader" {
+		t.Errorf("RoleDefinitionName changed. Expected Reader, got %s", got)
+	}
+	var gotCluster ra2.RoleAssignment
+	_ = cl.Get(ctx, client.ObjectKeyFromObject(cluster), &gotCluster)
+	if got := ptr.Deref(gotCluster.Spec.ForProvider.PrincipalID, ""); got != "test-principal-id" {
+		t.Errorf("PrincipalID incorrect. Expected test-principal-id, got %s", got)
+	}
+}
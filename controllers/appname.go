@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+const (
+	AppNameStrategySegment    = "segment"
+	AppNameStrategyRegex      = "regex"
+	AppNameStrategyLabel      = "label"
+	AppNameStrategyAnnotation = "annotation"
+
+	AppNameSourceForProvider  = "for-provider"
+	AppNameSourceExternalName = "external-name"
+
+	// DefaultAppNameKey is the label or annotation read by the label and annotation strategies.
+	DefaultAppNameKey = "clientid-operator/app-name"
+
+	externalNameAnnotation = "crossplane.io/external-name"
+)
+
+// AppNameExtractor derives the application name that links a UserAssignedIdentity to its
+// `workload-identity-{appName}` ServiceAccounts and labelled RoleAssignments.
+type AppNameExtractor interface {
+	ExtractAppName(identity client.Object) (string, error)
+}
+
+// AppNameConfig selects and configures an AppNameExtractor.
+type AppNameConfig struct {
+	// Strategy is one of segment, regex, label or annotation.
+	Strategy string
+	// Source is the name parsed by the segment and regex strategies: the identity's
+	// spec.forProvider.name (for-provider) or its crossplane.io/external-name annotation.
+	Source string
+	// Segment is the zero-based index of the dash-separated segment holding the app name.
+	Segment int
+	// Segments is the number of dash-separated segments a name must have. 0 accepts any
+	// name long enough to hold Segment.
+	Segments int
+	// Regex must contain a named capture group `app`.
+	Regex string
+	// Key is the label or annotation holding the app name.
+	Key string
+}
+
+// DefaultAppNameConfig matches names like `id-service-appname-dv-azunea-001`.
+func DefaultAppNameConfig() AppNameConfig {
+	return AppNameConfig{
+		Strategy: AppNameStrategySegment,
+		Source:   AppNameSourceForProvider,
+		Segment:  2,
+		Key:      DefaultAppNameKey,
+	}
+}
+
+var defaultAppNameExtractor AppNameExtractor = &segmentExtractor{source: forProviderName, index: 2}
+
+// NewAppNameExtractor validates cfg and returns the extractor it describes.
+func NewAppNameExtractor(cfg AppNameConfig) (AppNameExtractor, error) {
+	var source nameSource
+	switch cfg.Source {
+	case AppNameSourceForProvider, "":
+		source = forProviderName
+	case AppNameSourceExternalName:
+		source = externalName
+	default:
+		return nil, fmt.Errorf("unknown app name source %q", cfg.Source)
+	}
+
+	switch cfg.Strategy {
+	case AppNameStrategySegment, "":
+		if cfg.Segment < 0 {
+			return nil, fmt.Errorf("app name segment must not be negative, got %d", cfg.Segment)
+		}
+		if cfg.Segments < 0 {
+			return nil, fmt.Errorf("app name segment count must not be negative, got %d", cfg.Segments)
+		}
+		if cfg.Segments > 0 && cfg.Segment >= cfg.Segments {
+			return nil, fmt.Errorf("app name segment %d is out of range for names of %d segments", cfg.Segment, cfg.Segments)
+		}
+		return &segmentExtractor{source: source, index: cfg.Segment, segments: cfg.Segments}, nil
+	case AppNameStrategyRegex:
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid app name regex: %w", err)
+		}
+		group := re.SubexpIndex("app")
+		if group < 0 {
+			return nil, fmt.Errorf("app name regex %q has no named capture group `app`", cfg.Regex)
+		}
+		return &regexExtractor{source: source, re: re, group: group}, nil
+	case AppNameStrategyLabel, AppNameStrategyAnnotation:
+		if cfg.Key == "" {
+			return nil, fmt.Errorf("app name %s strategy requires a key", cfg.Strategy)
+		}
+		return &metadataExtractor{key: cfg.Key, annotation: cfg.Strategy == AppNameStrategyAnnotation}, nil
+	default:
+		return nil, fmt.Errorf("unknown app name strategy %q", cfg.Strategy)
+	}
+}
+
+// nameSource returns the Azure resource name of an identity.
+type nameSource func(identity client.Object) (string, error)
+
+func forProviderName(identity client.Object) (string, error) {
+	var name *string
+	switch id := identity.(type) {
+	case *mi.UserAssignedIdentity:
+		name = id.Spec.ForProvider.Name
+	case *mi2.UserAssignedIdentity:
+		name = id.Spec.ForProvider.Name
+	default:
+		return "", fmt.Errorf("unsupported identity type %T", identity)
+	}
+	if name == nil || *name == "" {
+		return "", fmt.Errorf("spec.forProvider.name is not set")
+	}
+	return *name, nil
+}
+
+func externalName(identity client.Object) (string, error) {
+	name := identity.GetAnnotations()[externalNameAnnotation]
+	if name == "" {
+		return "", fmt.Errorf("annotation %s is not set", externalNameAnnotation)
+	}
+	return name, nil
+}
+
+// segmentExtractor takes a fixed segment of the dash-separated name. Names with an empty
+// segment, or with other than segments segments when it is set, do not follow the
+// convention and are rejected rather than yielding the wrong app name.
+type segmentExtractor struct {
+	source   nameSource
+	index    int
+	segments int
+}
+
+func (e *segmentExtractor) ExtractAppName(identity client.Object) (string, error) {
+	name, err := e.source(identity)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(name, "-")
+	if e.segments > 0 && len(parts) != e.segments {
+		return "", fmt.Errorf("name %q has %d segments, expected %d", name, len(parts), e.segments)
+	}
+	if len(parts) <= e.index {
+		return "", fmt.Errorf("name %q has no segment %d", name, e.index)
+	}
+	if slices.Contains(parts, "") {
+		return "", fmt.Errorf("name %q has an empty segment", name)
+	}
+	return parts[e.index], nil
+}
+
+// regexExtractor takes the `app` capture group of a regular expression.
+type regexExtractor struct {
+	source nameSource
+	re     *regexp.Regexp
+	group  int
+}
+
+func (e *regexExtractor) ExtractAppName(identity client.Object) (string, error) {
+	name, err := e.source(identity)
+	if err != nil {
+		return "", err
+	}
+	match := e.re.FindStringSubmatch(name)
+	if match == nil || match[e.group] == "" {
+		return "", fmt.Errorf("name %q does not match %q", name, e.re.String())
+	}
+	return match[e.group], nil
+}
+
+// metadataExtractor reads the app name from a label or annotation on the identity.
+type metadataExtractor struct {
+	key        string
+	annotation bool
+}
+
+func (e *metadataExtractor) ExtractAppName(identity client.Object) (string, error) {
+	values, kind := identity.GetLabels(), "label"
+	if e.annotation {
+		values, kind = identity.GetAnnotations(), "annotation"
+	}
+	if values[e.key] == "" {
+		return "", fmt.Errorf("%s %s is not set", kind, e.key)
+	}
+	return values[e.key], nil
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+func namespacedIdentity(name string, labels, annotations map[string]string) *mi.UserAssignedIdentity {
+	identity := &mi.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: "identity", Namespace: "default", Labels: labels, Annotations: annotations},
+	}
+	if name != "" {
+		identity.Spec.ForProvider.Name = &name
+	}
+	return identity
+}
+
+func TestNewAppNameExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AppNameConfig
+		wantErr bool
+	}{
+		{name: "default", cfg: DefaultAppNameConfig()},
+		{name: "empty strategy defaults to segment", cfg: AppNameConfig{}},
+		{name: "negative segment", cfg: AppNameConfig{Strategy: AppNameStrategySegment, Segment: -1}, wantErr: true},
+		{name: "negative segment count", cfg: AppNameConfig{Strategy: AppNameStrategySegment, Segments: -1}, wantErr: true},
+		{name: "segment beyond segment count", cfg: AppNameConfig{Strategy: AppNameStrategySegment, Segment: 3, Segments: 3}, wantErr: true},
+		{name: "regex with app group", cfg: AppNameConfig{Strategy: AppNameStrategyRegex, Regex: `^id-(?P<app>.+)$`}},
+		{name: "regex without app group", cfg: AppNameConfig{Strategy: AppNameStrategyRegex, Regex: `^id-(.+)$`}, wantErr: true},
+		{name: "invalid regex", cfg: AppNameConfig{Strategy: AppNameStrategyRegex, Regex: `(?P<app>`}, wantErr: true},
+		{name: "label without key", cfg: AppNameConfig{Strategy: AppNameStrategyLabel}, wantErr: true},
+		{name: "annotation with key", cfg: AppNameConfig{Strategy: AppNameStrategyAnnotation, Key: DefaultAppNameKey}},
+		{name: "unknown strategy", cfg: AppNameConfig{Strategy: "guess"}, wantErr: true},
+		{name: "unknown source", cfg: AppNameConfig{Strategy: AppNameStrategySegment, Source: "status"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAppNameExtractor(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAppNameExtractor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAppNameExtractor_Segment(t *testing.T) {
+	sixSegments := DefaultAppNameConfig()
+	sixSegments.Segments = 6
+	tests := []struct {
+		name     string
+		cfg      AppNameConfig
+		identity client.Object
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "default convention",
+			cfg:      DefaultAppNameConfig(),
+			identity: namespacedIdentity("id-service-testapp-dv-azunea-001", nil, nil),
+			want:     "testapp",
+		},
+		{
+			name: "cluster-scoped identity",
+			cfg:  DefaultAppNameConfig(),
+			identity: func() client.Object {
+				name := "id-service-clusterapp-dv-azunea-001"
+				return &mi2.UserAssignedIdentity{Spec: mi2.UserAssignedIdentitySpec{ForProvider: mi2.UserAssignedIdentityParameters{Name: &name}}}
+			}(),
+			want: "clusterapp",
+		},
+		{
+			name:     "custom segment",
+			cfg:      AppNameConfig{Strategy: AppNameStrategySegment, Segment: 1},
+			identity: namespacedIdentity("id-testapp-001", nil, nil),
+			want:     "testapp",
+		},
+		{
+			name:     "too few segments",
+			cfg:      DefaultAppNameConfig(),
+			identity: namespacedIdentity("id-service", nil, nil),
+			wantErr:  true,
+		},
+		{
+			name:     "empty segment",
+			cfg:      DefaultAppNameConfig(),
+			identity: namespacedIdentity("id-service--dv", nil, nil),
+			wantErr:  true,
+		},
+		{
+			// Names with fewer segments than the convention were accepted before the segment count was configurable
+			name:     "four segment name",
+			cfg:      DefaultAppNameConfig(),
+			identity: namespacedIdentity("id-service-testapp-001", nil, nil),
+			want:     "testapp",
+		},
+		{
+			name:     "missing suffix segment",
+			cfg:      sixSegments,
+			identity: namespacedIdentity("id-service-testapp-dv-azunea", nil, nil),
+			wantErr:  true,
+		},
+		{
+			name:     "extra segment",
+			cfg:      sixSegments,
+			identity: namespacedIdentity("id-service-test-app-dv-azunea-001", nil, nil),
+			wantErr:  true,
+		},
+		{
+			name:     "empty prefix segment",
+			cfg:      DefaultAppNameConfig(),
+			identity: namespacedIdentity("-service-testapp-dv-azunea-001", nil, nil),
+			wantErr:  true,
+		},
+		{
+			name:     "any segment count",
+			cfg:      AppNameConfig{Strategy: AppNameStrategySegment, Segment: 2},
+			identity: namespacedIdentity("id-service-testapp-001", nil, nil),
+			want:     "testapp",
+		},
+		{
+			name:     "missing forProvider name",
+			cfg:      DefaultAppNameConfig(),
+			identity: namespacedIdentity("", nil, nil),
+			wantErr:  true,
+		},
+		{
+			name: "external name source",
+			cfg:  AppNameConfig{Strategy: AppNameStrategySegment, Source: AppNameSourceExternalName, Segment: 2},
+			identity: namespacedIdentity("ignored", nil, map[string]string{
+				"crossplane.io/external-name": "id-service-external-dv-azunea-001",
+			}),
+			want: "external",
+		},
+		{
+			name:     "external name missing",
+			cfg:      AppNameConfig{Strategy: AppNameStrategySegment, Source: AppNameSourceExternalName, Segment: 2},
+			identity: namespacedIdentity("id-service-testapp-dv-azunea-001", nil, nil),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertAppName(t, tt.cfg, tt.identity, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestAppNameExtractor_Regex(t *testing.T) {
+	cfg := AppNameConfig{
+		Strategy: AppNameStrategyRegex,
+		Regex:    `^id-service-(?P<app>.+)-[a-z]{2}-[a-z]+-\d{3}$`,
+	}
+	tests := []struct {
+		name     string
+		identity client.Object
+		want     string
+		wantErr  bool
+	}{
+		{name: "single word app", identity: namespacedIdentity("id-service-testapp-dv-azunea-001", nil, nil), want: "testapp"},
+		{name: "app name with dashes", identity: namespacedIdentity("id-service-order-api-dv-azunea-001", nil, nil), want: "order-api"},
+		{name: "no match", identity: namespacedIdentity("workload-identity-testapp", nil, nil), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertAppName(t, cfg, tt.identity, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestAppNameExtractor_Metadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      AppNameConfig
+		identity client.Object
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "label",
+			cfg:      AppNameConfig{Strategy: AppNameStrategyLabel, Key: DefaultAppNameKey},
+			identity: namespacedIdentity("irrelevant", map[string]string{DefaultAppNameKey: "labelled"}, nil),
+			want:     "labelled",
+		},
+		{
+			name:     "label missing",
+			cfg:      AppNameConfig{Strategy: AppNameStrategyLabel, Key: DefaultAppNameKey},
+			identity: namespacedIdentity("irrelevant", nil, map[string]string{DefaultAppNameKey: "annotated"}),
+			wantErr:  true,
+		},
+		{
+			name:     "annotation",
+			cfg:      AppNameConfig{Strategy: AppNameStrategyAnnotation, Key: DefaultAppNameKey},
+			identity: namespacedIdentity("irrelevant", nil, map[string]string{DefaultAppNameKey: "annotated"}),
+			want:     "annotated",
+		},
+		{
+			name:     "annotation missing",
+			cfg:      AppNameConfig{Strategy: AppNameStrategyAnnotation, Key: DefaultAppNameKey},
+			identity: namespacedIdentity("irrelevant", map[string]string{DefaultAppNameKey: "labelled"}, nil),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertAppName(t, tt.cfg, tt.identity, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func assertAppName(t *testing.T, cfg AppNameConfig, identity client.Object, want string, wantErr bool) {
+	t.Helper()
+	extractor, err := NewAppNameExtractor(cfg)
+	if err != nil {
+		t.Fatalf("NewAppNameExtractor() error = %v", err)
+	}
+	got, err := extractor.ExtractAppName(identity)
+	if (err != nil) != wantErr {
+		t.Fatalf("ExtractAppName() error = %v, wantErr %v", err, wantErr)
+	}
+	if got != want {
+		t.Errorf("ExtractAppName() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	CleanupPolicyOrphan = "orphan"
+	CleanupPolicyClear  = "clear"
+	CleanupPolicyDelete = "delete"
+
+	// cleanupPolicyAnnotation on a UserAssignedIdentity overrides the global cleanup policy.
+	cleanupPolicyAnnotation = "clientid-operator/cleanup-policy"
+	// cleanupFinalizer holds back the deletion of an identity until its dependents are cleaned up.
+	cleanupFinalizer = "clientid-operator/cleanup"
+	// pausedAnnotation stops Crossplane from reconciling a managed resource.
+	pausedAnnotation = "crossplane.io/paused"
+)
+
+// ValidateCleanupPolicy checks that policy is known.
+func ValidateCleanupPolicy(policy string) error {
+	switch policy {
+	case CleanupPolicyOrphan, CleanupPolicyClear, CleanupPolicyDelete:
+		return nil
+	default:
+		return fmt.Errorf("unknown cleanup policy %q", policy)
+	}
+}
+
+// cleanupPolicy returns the cleanup policy of identity, falling back to the global policy
+// when the identity has no valid policy of its own.
+func (r *UserAssignedIdentityReconciler) cleanupPolicy(identity client.Object) string {
+	global := r.CleanupPolicy
+	if global == "" {
+		global = CleanupPolicyOrphan
+	}
+	policy, ok := identity.GetAnnotations()[cleanupPolicyAnnotation]
+	if !ok {
+		return global
+	}
+	if err := ValidateCleanupPolicy(policy); err != nil {
+		r.event(identity, corev1.EventTypeWarning, "InvalidCleanupPolicy", fmt.Sprintf("Ignoring %s: %v, using %s", cleanupPolicyAnnotation, err, global))
+		return global
+	}
+	return policy
+}
+
+// setCleanupFinalizer adds the cleanup finalizer to identity when want is set and removes it otherwise.
+func (r *UserAssignedIdentityReconciler) setCleanupFinalizer(ctx context.Context, identity client.Object, want bool) error {
+	if controllerutil.ContainsFinalizer(identity, cleanupFinalizer) == want {
+		return nil
+	}
+	patch := client.MergeFrom(identity.DeepCopyObject().(client.Object))
+	if want {
+		controllerutil.AddFinalizer(identity, cleanupFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(identity, cleanupFinalizer)
+	}
+	return r.Patch(ctx, identity, patch)
+}
+
+// finalizeIdentity cleans up after a deleted identity according to its cleanup policy and
+// then releases it. ServiceAccounts lose their client ID annotation if it still holds
+// clientID; RoleAssignments still pointing at principalID are paused under the clear policy
+// and deleted under delete. Dependents already taken over by another identity of the same
+// app are left alone.
+func (r *UserAssignedIdentityReconciler) finalizeIdentity(ctx context.Context, identity client.Object, clientID, principalID *string, log logr.Logger) (ctrl.Result, error) {
+	key := client.ObjectKeyFromObject(identity)
+	outOfSyncIdentities.forget(key)
+	if !controllerutil.ContainsFinalizer(identity, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	policy := r.cleanupPolicy(identity)
+	appName, err := r.extractAppName(identity)
+	if policy != CleanupPolicyOrphan && err == nil {
+		clearedServiceAccounts, err := r.clearServiceAccounts(ctx, appName, clientID, nil, "deleted UserAssignedIdentity", log)
+		if err != nil {
+			log.Error(err, "Failed to clear ServiceAccounts of deleted UserAssignedIdentity")
+			r.event(identity, corev1.EventTypeWarning, "CleanupFailed", fmt.Sprintf("Failed to clear ServiceAccounts: %v", err))
+			return ctrl.Result{}, err
+		}
+		cleanedRoleAssignments, err := r.cleanupRoleAssignments(ctx, identity, appName, principalID, policy, nil, "deleted UserAssignedIdentity", log)
+		if err != nil {
+			log.Error(err, "Failed to clean up RoleAssignments of deleted UserAssignedIdentity")
+			r.event(identity, corev1.EventTypeWarning, "CleanupFailed", fmt.Sprintf("Failed to clean up RoleAssignments: %v", err))
+			return ctrl.Result{}, err
+		}
+		action := "paused"
+		if policy == CleanupPolicyDelete {
+			action = "deleted"
+		}
+		r.event(identity, corev1.EventTypeNormal, "CleanedUp", fmt.Sprintf("Cleanup policy %s: cleared %d ServiceAccount(s), %s %d RoleAssignment(s)",
+			policy, clearedServiceAccounts, action, cleanedRoleAssignments))
+	} else if err != nil {
+		log.Info("Cannot extract appName, releasing UserAssignedIdentity without cleanup", "error", err.Error())
+	}
+
+	if err := r.setCleanupFinalizer(ctx, identity, false); err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// clearServiceAccounts removes the client ID annotation from the ServiceAccounts of appName
+// that still carry clientID and are not kept, so they do not point at an identity that no
+// longer exists, or no longer trusts them. from names that identity in logs and Events.
+func (r *UserAssignedIdentityReconciler) clearServiceAccounts(ctx context.Context, appName string, clientID *string, keep func(client.Object) bool, from string, log logr.Logger) (int, error) {
+	if clientID == nil || *clientID == "" {
+		return 0, nil
+	}
+	keys, err := r.conventionServiceAccounts(ctx, appName)
+	if err != nil {
+		return 0, err
+	}
+	cleared := 0
+	for _, key := range keys {
+		var sa corev1.ServiceAccount
+		if err := r.Get(ctx, key, &sa); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return cleared, err
+		}
+		if sa.Annotations[clientIDAnnotation] != *clientID || (keep != nil && keep(&sa)) {
+			continue
+		}
+		patch := client.MergeFrom(sa.DeepCopy())
+		delete(sa.Annotations, clientIDAnnotation)
+		if err := r.Patch(ctx, &sa, patch); err != nil {
+			return cleared, err
+		}
+		log.Info("Cleared client ID of "+from, "ServiceAccount", key)
+		r.event(&sa, corev1.EventTypeNormal, "ClientIDCleared", fmt.Sprintf("Removed azure.workload.identity/client-id %s of %s", *clientID, from))
+		cleared++
+	}
+	return cleared, nil
+}
+
+// cleanupRoleAssignments pauses or, under the delete policy, deletes the RoleAssignments of
+// appName in the RoleAssignment scope of identity that still point at principalID and are not
+// kept. from names identity in logs.
+func (r *UserAssignedIdentityReconciler) cleanupRoleAssignments(ctx context.Context, identity client.Object, appName string, principalID *string, policy string, keep func(client.Object) bool, from string, log logr.Logger) (int, error) {
+	if principalID == nil || *principalID == "" {
+		return 0, nil
+	}
+	roleAssignments := r.listRoleAssignments(ctx, conventionRoleAssignmentSelector(appName), r.roleAssignmentScopeFor(identity), log)
+
+	cleaned := 0
+	for _, roleAssignment := range roleAssignments {
+		if current := rolePrincipalID(roleAssignment); current == nil || *current != *principalID || (keep != nil && keep(roleAssignment)) {
+			continue
+		}
+		if policy == CleanupPolicyDelete {
+			if err := r.Delete(ctx, roleAssignment); client.IgnoreNotFound(err) != nil {
+				return cleaned, err
+			}
+			log.Info("Deleted RoleAssignment of "+from, "name", roleAssignment.GetName())
+			cleaned++
+			continue
+		}
+		if roleAssignment.GetAnnotations()[pausedAnnotation] == "true" {
+			continue
+		}
+		patch := client.MergeFrom(roleAssignment.DeepCopyObject().(client.Object))
+		annotations := roleAssignment.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[pausedAnnotation] = "true"
+		roleAssignment.SetAnnotations(annotations)
+		if err := r.Patch(ctx, roleAssignment, patch); err != nil {
+			return cleaned, err
+		}
+		log.Info("Paused RoleAssignment of "+from, "name", roleAssignment.GetName())
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// handOverToBindings releases what the naming convention set up for identity once it is bound
+// by an IdentityBinding, apart from what its bindings cover. The ServiceAccounts of its app
+// lose their client ID annotation, since the bindings' FederatedIdentityCredentials no longer
+// trust them, and its RoleAssignments are cleaned up under its cleanup policy as on deletion.
+// Its other FederatedIdentityCredentials are pruned by the IdentityBinding reconciler. The
+// hand-over runs once: the sync summary annotations the convention left on identity mark it
+// and are removed when it is done.
+func (r *UserAssignedIdentityReconciler) handOverToBindings(ctx context.Context, identity client.Object, clientID, principalID *string, log logr.Logger) error {
+	if _, ok := identity.GetAnnotations()[lastSyncResultAnnotation]; !ok {
+		return nil
+	}
+	bindings, err := r.identityBindings(ctx, client.ObjectKeyFromObject(identity))
+	if err != nil {
+		return err
+	}
+
+	if appName, err := r.extractAppName(identity); err == nil {
+		bound := map[types.NamespacedName]bool{}
+		var selectors []labels.Selector
+		// The dependents of a binding with an invalid selector are unknown, so none are released
+		keepServiceAccounts, keepRoleAssignments := false, false
+		for i := range bindings {
+			serviceAccounts, err := r.boundServiceAccounts(ctx, &bindings[i])
+			if err != nil {
+				keepServiceAccounts = true
+			}
+			for _, sa := range serviceAccounts {
+				bound[sa] = true
+			}
+			if bindings[i].Spec.RoleAssignmentSelector == nil {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(bindings[i].Spec.RoleAssignmentSelector)
+			if err != nil {
+				keepRoleAssignments = true
+				continue
+			}
+			selectors = append(selectors, selector)
+		}
+
+		const from = "UserAssignedIdentity handed over to an IdentityBinding"
+		cleared, err := r.clearServiceAccounts(ctx, appName, clientID, func(sa client.Object) bool {
+			return keepServiceAccounts || bound[client.ObjectKeyFromObject(sa)]
+		}, from, log)
+		if err != nil {
+			return err
+		}
+		cleaned := 0
+		if policy := r.cleanupPolicy(identity); policy != CleanupPolicyOrphan {
+			cleaned, err = r.cleanupRoleAssignments(ctx, identity, appName, principalID, policy, func(roleAssignment client.Object) bool {
+				return keepRoleAssignments || slices.ContainsFunc(selectors, func(selector labels.Selector) bool {
+					return selector.Matches(labels.Set(roleAssignment.GetLabels()))
+				})
+			}, from, log)
+			if err != nil {
+				return err
+			}
+		}
+		r.event(identity, corev1.EventTypeNormal, "HandedOver", fmt.Sprintf("Bound by an IdentityBinding: cleared %d ServiceAccount(s), cleaned up %d RoleAssignment(s) of the naming convention", cleared, cleaned))
+	}
+
+	patch := client.MergeFrom(identity.DeepCopyObject().(client.Object))
+	annotations := identity.GetAnnotations()
+	for _, annotation := range []string{lastSyncTimeAnnotation, lastSyncResultAnnotation, serviceAccountsPatchedAnnotation,
+		roleAssignmentsUpdatedAnnotation, workloadsRestartedAnnotation, missingTargetNamespacesAnnotation} {
+		delete(annotations, annotation)
+	}
+	identity.SetAnnotations(annotations)
+	return r.Patch(ctx, identity, patch)
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// cleanupObjects is an identity for testapp with the given annotations, its ServiceAccount
+// and RoleAssignment, and a ServiceAccount already pointing at another identity.
+func cleanupObjects(annotations map[string]string) []client.Object {
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Annotations = annotations
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	return []client.Object{
+		identity,
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "workload-identity-testapp", Namespace: "team-a",
+			Annotations: map[string]string{"azure.workload.identity/client-id": "test-client-id"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "workload-identity-testapp", Namespace: "team-b",
+			Annotations: map[string]string{"azure.workload.identity/client-id": "other-client-id"},
+		}},
+		&ra.RoleAssignment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ra-testapp", Namespace: "default", Labels: map[string]string{"application": "testapp", "type": "roleassignment"},
+			},
+			Spec: ra.RoleAssignmentSpec{ForProvider: ra.RoleAssignmentParameters{PrincipalID: ptr.To("test-principal-id")}},
+		},
+	}
+}
+
+// deleteIdentity deletes the testapp identity holding the cleanup finalizer and reconciles it.
+func deleteIdentity(t *testing.T, cl client.Client, r *UserAssignedIdentityReconciler) {
+	t.Helper()
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "testapp", Namespace: "default"}}
+	var identity mi.UserAssignedIdentity
+	if err := cl.Get(ctx, req.NamespacedName, &identity); err != nil {
+		t.Fatalf("Failed to get identity: %v", err)
+	}
+	if err := r.setCleanupFinalizer(ctx, &identity, true); err != nil {
+		t.Fatalf("Failed to add finalizer: %v", err)
+	}
+	if err := cl.Delete(ctx, &identity); err != nil {
+		t.Fatalf("Failed to delete identity: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if err := cl.Get(ctx, req.NamespacedName, &identity); !errors.IsNotFound(err) {
+		t.Errorf("Expected identity to be released, got finalizers %v", identity.Finalizers)
+	}
+}
+
+func clientIDOf(t *testing.T, cl client.Client, namespace string) string {
+	t.Helper()
+	var sa corev1.ServiceAccount
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: "workload-identity-testapp", Namespace: namespace}, &sa); err != nil {
+		t.Fatalf("Failed to get ServiceAccount: %v", err)
+	}
+	return sa.Annotations["azure.workload.identity/client-id"]
+}
+
+func TestCleanupPolicy_Orphan(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, cleanupObjects(nil)...)
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	var identity mi.UserAssignedIdentity
+	if err := cl.Get(ctx, key, &identity); err != nil {
+		t.Fatalf("Failed to get identity: %v", err)
+	}
+	if controllerutil.ContainsFinalizer(&identity, cleanupFinalizer) {
+		t.Error("Expected no finalizer without a cleanup policy")
+	}
+
+	r.CleanupPolicy = CleanupPolicyClear
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	_ = cl.Get(ctx, key, &identity)
+	if !controllerutil.ContainsFinalizer(&identity, cleanupFinalizer) {
+		t.Fatal("Expected the cleanup finalizer under the clear policy")
+	}
+	// Opting out again removes the finalizer
+	identity.Annotations[cleanupPolicyAnnotation] = CleanupPolicyOrphan
+	if err := cl.Update(ctx, &identity); err != nil {
+		t.Fatalf("Failed to update identity: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	_ = cl.Get(ctx, key, &identity)
+	if controllerutil.ContainsFinalizer(&identity, cleanupFinalizer) {
+		t.Error("Expected the orphan annotation to remove the finalizer")
+	}
+}
+
+func TestCleanupPolicy_Clear(t *testing.T) {
+	cl, r, recorder := newTestReconciler(t, cleanupObjects(map[string]string{cleanupPolicyAnnotation: CleanupPolicyClear})...)
+	deleteIdentity(t, cl, r)
+
+	if got := clientIDOf(t, cl, "team-a"); got != "" {
+		t.Errorf("Client ID annotation not cleared. Expected none, got %s", got)
+	}
+	if got := clientIDOf(t, cl, "team-b"); got != "other-client-id" {
+		t.Errorf("Client ID of another identity changed. Expected other-client-id, got %s", got)
+	}
+	var roleAssignment ra.RoleAssignment
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: "ra-testapp", Namespace: "default"}, &roleAssignment); err != nil {
+		t.Fatalf("Failed to get RoleAssignment: %v", err)
+	}
+	if roleAssignment.Annotations[pausedAnnotation] != "true" {
+		t.Errorf("RoleAssignment not paused. Expected %s=true, got %v", pausedAnnotation, roleAssignment.Annotations)
+	}
+
+	found := false
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.HasPrefix(event, "Normal CleanedUp") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected a CleanedUp event")
+	}
+}
+
+func TestCleanupPolicy_Delete(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, cleanupObjects(nil)...)
+	r.CleanupPolicy = CleanupPolicyDelete
+	deleteIdentity(t, cl, r)
+
+	if got := clientIDOf(t, cl, "team-a"); got != "" {
+		t.Errorf("Client ID annotation not cleared. Expected none, got %s", got)
+	}
+	var roleAssignment ra.RoleAssignment
+	err := cl.Get(context.Background(), types.NamespacedName{Name: "ra-testapp", Namespace: "default"}, &roleAssignment)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected RoleAssignment to be deleted, got %v", err)
+	}
+}
+
+func TestCleanupPolicy_SharedAppName(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, cleanupObjects(nil)...)
+	r.CleanupPolicy = CleanupPolicyDelete
+	ctx := context.Background()
+
+	// The identity was recreated under another name, and its successor already took over
+	// the RoleAssignment and the ServiceAccount in team-b
+	successor := identityNamed("testapp-v2", "default", "id-service-testapp-dv-azunea-002")
+	successor.Status.AtProvider.ClientID = ptr.To("other-client-id")
+	successor.Status.AtProvider.PrincipalID = ptr.To("new-principal-id")
+	var taken ra.RoleAssignment
+	if err := cl.Get(ctx, types.NamespacedName{Name: "ra-testapp", Namespace: "default"}, &taken); err != nil {
+		t.Fatalf("Failed to get RoleAssignment: %v", err)
+	}
+	taken.Spec.ForProvider.PrincipalID = successor.Status.AtProvider.PrincipalID
+	stale := &ra.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp-stale", Namespace: "default", Labels: taken.Labels},
+		Spec:       ra.RoleAssignmentSpec{ForProvider: ra.RoleAssignmentParameters{PrincipalID: ptr.To("test-principal-id")}},
+	}
+	for _, err := range []error{cl.Update(ctx, &taken), cl.Create(ctx, successor), cl.Create(ctx, stale)} {
+		if err != nil {
+			t.Fatalf("Failed to seed the successor: %v", err)
+		}
+	}
+
+	deleteIdentity(t, cl, r)
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(&taken), &taken); err != nil {
+		t.Errorf("Expected the RoleAssignment of the successor to be kept, got %v", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(stale), stale); !errors.IsNotFound(err) {
+		t.Errorf("Expected the RoleAssignment of the deleted identity to be deleted, got %v", err)
+	}
+	if got := clientIDOf(t, cl, "team-b"); got != "other-client-id" {
+		t.Errorf("Client ID of the successor changed. Expected other-client-id, got %s", got)
+	}
+}
+
+func TestCleanupPolicy_InvalidAnnotation(t *testing.T) {
+	_, r, recorder := newTestReconciler(t, cleanupObjects(nil)...)
+	r.CleanupPolicy = CleanupPolicyClear
+
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Annotations = map[string]string{cleanupPolicyAnnotation: "shred"}
+	if got := r.cleanupPolicy(identity); got != CleanupPolicyClear {
+		t.Errorf("cleanupPolicy() = %s, want %s", got, CleanupPolicyClear)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning InvalidCleanupPolicy") {
+		t.Errorf("Unexpected event %q", event)
+	}
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dryRunEventPrefix marks the message of every Event recorded in dry-run mode.
+const dryRunEventPrefix = "[dry-run] "
+
+// dryRunClient reads through the wrapped client but only logs and counts writes, so the
+// reconcilers compute every change they would make without making it.
+type dryRunClient struct {
+	client.Client
+	log logr.Logger
+}
+
+// NewDryRunClient returns a client that passes reads to c and skips all writes, logging each
+// of them and counting them in clientid_operator_dry_run_writes_total.
+func NewDryRunClient(c client.Client, log logr.Logger) client.Client {
+	return &dryRunClient{Client: c, log: log}
+}
+
+func (c *dryRunClient) skip(verb string, obj client.Object, keysAndValues ...any) {
+	kind := "Unknown"
+	if gvk, err := c.GroupVersionKindFor(obj); err == nil {
+		kind = gvk.Kind
+	}
+	c.log.Info("Dry run, skipping "+verb, append([]any{"kind", kind, "object", client.ObjectKeyFromObject(obj)}, keysAndValues...)...)
+	dryRunWritesTotal.WithLabelValues(verb, kind).Inc()
+}
+
+func (c *dryRunClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	c.skip("create", obj)
+	return nil
+}
+
+func (c *dryRunClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.skip("update", obj)
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, _ := patch.Data(obj)
+	if releasesDeletedObject(obj, patch, data) {
+		// Holding back the finalizer of a deleted object would leave it Terminating
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	c.skip("patch", obj, "patch", string(data))
+	return nil
+}
+
+// releasesDeletedObject reports whether patch only changes the finalizers of obj after it has
+// been deleted, which is how a finalizer left behind by an earlier run is released.
+func releasesDeletedObject(obj client.Object, patch client.Patch, data []byte) bool {
+	if obj.GetDeletionTimestamp().IsZero() || patch.Type() != types.MergePatchType {
+		return false
+	}
+	var fields struct {
+		Metadata map[string]json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil || len(top) != 1 {
+		return false
+	}
+	for field := range fields.Metadata {
+		if field != "finalizers" && field != "resourceVersion" {
+			return false
+		}
+	}
+	_, ok := fields.Metadata["finalizers"]
+	return ok
+}
+
+func (c *dryRunClient) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	c.skip("delete", obj)
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(_ context.Context, obj client.Object, _ ...client.DeleteAllOfOption) error {
+	c.skip("deletecollection", obj)
+	return nil
+}
+
+func (c *dryRunClient) Apply(_ context.Context, obj runtime.ApplyConfiguration, _ ...client.ApplyOption) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	c.skip("apply", u, "patch", string(data))
+	return nil
+}
+
+func (c *dryRunClient) Status() client.SubResourceWriter {
+	return &dryRunSubResourceClient{SubResourceClient: c.Client.SubResource("status"), client: c, subResource: "status"}
+}
+
+func (c *dryRunClient) SubResource(subResource string) client.SubResourceClient {
+	return &dryRunSubResourceClient{SubResourceClient: c.Client.SubResource(subResource), client: c, subResource: subResource}
+}
+
+// dryRunSubResourceClient reads subresources through the wrapped client and skips writes to them.
+type dryRunSubResourceClient struct {
+	client.SubResourceClient
+	client      *dryRunClient
+	subResource string
+}
+
+func (c *dryRunSubResourceClient) Create(_ context.Context, obj client.Object, _ client.Object, _ ...client.SubResourceCreateOption) error {
+	c.client.skip("create "+c.subResource, obj)
+	return nil
+}
+
+func (c *dryRunSubResourceClient) Update(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	c.client.skip("update "+c.subResource, obj)
+	return nil
+}
+
+func (c *dryRunSubResourceClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.SubResourcePatchOption) error {
+	data, _ := patch.Data(obj)
+	c.client.skip("patch "+c.subResource, obj, "patch", string(data))
+	return nil
+}
+
+// dryRunRecorder marks every Event as a dry run, since the change it reports was not made.
+type dryRunRecorder struct {
+	record.EventRecorder
+}
+
+// NewDryRunRecorder returns a recorder that prefixes the messages of the Events recorded
+// through recorder with "[dry-run] ".
+func NewDryRunRecorder(recorder record.EventRecorder) record.EventRecorder {
+	return &dryRunRecorder{EventRecorder: recorder}
+}
+
+func (r *dryRunRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, dryRunEventPrefix+message)
+}
+
+func (r *dryRunRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...any) {
+	r.EventRecorder.Eventf(object, eventtype, reason, dryRunEventPrefix+messageFmt, args...)
+}
+
+func (r *dryRunRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...any) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, dryRunEventPrefix+messageFmt, args...)
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestDryRun(t *testing.T) {
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	objs := []client.Object{
+		identity,
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: "default"}},
+		rolledOutDeployment("testapp"),
+		&ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{
+			Name: "ra-testapp", Namespace: "default", Labels: map[string]string{"application": "testapp", "type": "roleassignment"},
+		}},
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+	log := zap.New(zap.UseDevMode(true))
+	recorder := record.NewFakeRecorder(20)
+	r := &UserAssignedIdentityReconciler{
+		Client:        NewDryRunClient(cl, log),
+		Scheme:        s,
+		Log:           log,
+		Recorder:      NewDryRunRecorder(recorder),
+		ResyncPeriod:  time.Hour,
+		CleanupPolicy: CleanupPolicyClear,
+		DryRun:        true,
+	}
+
+	applies := dryRunWritesTotal.WithLabelValues("apply", "ServiceAccount")
+	patches := dryRunWritesTotal.WithLabelValues("patch", "Deployment")
+	appliesBefore, patchesBefore := testutil.ToFloat64(applies), testutil.ToFloat64(patches)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	outOfSyncIdentities.forget(key)
+
+	var sa corev1.ServiceAccount
+	_ = cl.Get(ctx, types.NamespacedName{Name: "workload-identity-testapp", Namespace: "default"}, &sa)
+	if got := sa.Annotations["azure.workload.identity/client-id"]; got != "" {
+		t.Errorf("ServiceAccount changed in dry-run mode. Expected no client ID, got %s", got)
+	}
+	if restarted(t, cl, "testapp") {
+		t.Error("Deployment restarted in dry-run mode")
+	}
+	var roleAssignment ra.RoleAssignment
+	_ = cl.Get(ctx, types.NamespacedName{Name: "ra-testapp", Namespace: "default"}, &roleAssignment)
+	if roleAssignment.Spec.ForProvider.PrincipalID != nil {
+		t.Errorf("RoleAssignment changed in dry-run mode. Expected no principal ID, got %s", *roleAssignment.Spec.ForProvider.PrincipalID)
+	}
+	var current mi.UserAssignedIdentity
+	_ = cl.Get(ctx, key, &current)
+	if _, ok := current.Annotations[lastSyncResultAnnotation]; ok {
+		t.Error("Sync summary recorded on the identity in dry-run mode")
+	}
+	if controllerutil.ContainsFinalizer(&current, cleanupFinalizer) {
+		t.Error("Cleanup finalizer added in dry-run mode")
+	}
+
+	if got := testutil.ToFloat64(applies) - appliesBefore; got != 1 {
+		t.Errorf("Expected 1 skipped ServiceAccount apply, got %v", got)
+	}
+	if got := testutil.ToFloat64(patches) - patchesBefore; got != 1 {
+		t.Errorf("Expected 1 skipped Deployment patch, got %v", got)
+	}
+	if result.RequeueAfter != time.Hour {
+		t.Errorf("Expected the skipped changes to wait for the resync, got RequeueAfter %v", result.RequeueAfter)
+	}
+
+	if len(recorder.Events) == 0 {
+		t.Fatal("Expected the would-be changes to be recorded as Events")
+	}
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; !strings.Contains(event, dryRunEventPrefix) {
+			t.Errorf("Event %q not marked as dry run", event)
+		}
+	}
+}
+
+func TestDryRunReleasesDeletedIdentity(t *testing.T) {
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Finalizers = []string{cleanupFinalizer}
+	cl, r, _ := newTestReconciler(t, identity,
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "workload-identity-testapp", Namespace: "default",
+			Annotations: map[string]string{"azure.workload.identity/client-id": "test-client-id"},
+		}})
+	r.Client = NewDryRunClient(cl, r.Log)
+	r.CleanupPolicy = CleanupPolicyClear
+	r.DryRun = true
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	if err := cl.Delete(ctx, identity); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	var current mi.UserAssignedIdentity
+	if err := cl.Get(ctx, key, &current); !errors.IsNotFound(err) {
+		t.Errorf("Expected the deleted identity to be released, got finalizers %v", current.Finalizers)
+	}
+	var sa corev1.ServiceAccount
+	_ = cl.Get(ctx, types.NamespacedName{Name: "workload-identity-testapp", Namespace: "default"}, &sa)
+	if got := sa.Annotations["azure.workload.identity/client-id"]; got != "test-client-id" {
+		t.Errorf("ServiceAccount cleared in dry-run mode, got client ID %q", got)
+	}
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/go-logr/logr"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+const (
+	// federatedCredentialIdentityLabel links a FederatedIdentityCredential to the identity it
+	// was created for, which lives in the same namespace.
+	federatedCredentialIdentityLabel = "clientid-operator/identity"
+
+	federatedCredentialAudience = "api://AzureADTokenExchange"
+	// maxFederatedCredentialName is the longest name Azure accepts for a federated credential.
+	maxFederatedCredentialName = 120
+	// maxLabelValue is the longest label value Kubernetes accepts.
+	maxLabelValue = 63
+)
+
+// federatedCredentialSync lists the changes made by syncFederatedCredentials.
+type federatedCredentialSync struct {
+	UpdatedFederatedCredentials []string
+	PrunedFederatedCredentials  []string
+}
+
+// federatedCredentialName returns the name of the credential trusting sa for identityName,
+// shortened with a hash when it would exceed what Azure accepts.
+func federatedCredentialName(identityName string, sa types.NamespacedName) string {
+	name := strings.ReplaceAll(fmt.Sprintf("%s-%s-%s", identityName, sa.Namespace, sa.Name), ".", "-")
+	return shortenName(name, maxFederatedCredentialName)
+}
+
+// federatedCredentialIdentity returns the federatedCredentialIdentityLabel value for
+// identityName, shortened with a hash when the name is too long for a label.
+func federatedCredentialIdentity(identityName string) string {
+	return shortenName(identityName, maxLabelValue)
+}
+
+// shortenName returns name, or when it is longer than limit, its start followed by a hash of
+// the whole name, so distinct names stay distinct.
+func shortenName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:limit-9] + "-" + hex.EncodeToString(sum[:4])
+}
+
+// federatedCredentialSubject is the token subject of sa.
+func federatedCredentialSubject(sa types.NamespacedName) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", sa.Namespace, sa.Name)
+}
+
+// federatedCredentials returns the credentials labelled for identity, in its own scope.
+func (r *UserAssignedIdentityReconciler) federatedCredentials(ctx context.Context, identity client.Object) ([]client.Object, error) {
+	opts := []client.ListOption{client.MatchingLabels{federatedCredentialIdentityLabel: federatedCredentialIdentity(identity.GetName())}}
+	var credentials []client.Object
+	if identity.GetNamespace() == "" {
+		var list mi2.FederatedIdentityCredentialList
+		if err := r.List(ctx, &list, opts...); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			credentials = append(credentials, &list.Items[i])
+		}
+		return credentials, nil
+	}
+	var list mi.FederatedIdentityCredentialList
+	if err := r.List(ctx, &list, append(opts, client.InNamespace(identity.GetNamespace()))...); err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		credentials = append(credentials, &list.Items[i])
+	}
+	return credentials, nil
+}
+
+// syncFederatedCredentials makes identity trust the cluster's OIDC issuer for every
+// ServiceAccount in serviceAccounts, and prunes the credentials it created for
+// ServiceAccounts no longer among them. It does nothing without an issuer URL.
+func (r *UserAssignedIdentityReconciler) syncFederatedCredentials(ctx context.Context, identity client.Object, serviceAccounts []types.NamespacedName, log logr.Logger) (federatedCredentialSync, error) {
+	var result federatedCredentialSync
+	if r.OIDCIssuerURL == "" {
+		return result, nil
+	}
+
+	existing, err := r.federatedCredentials(ctx, identity)
+	if err != nil {
+		return result, err
+	}
+	identityObject, err := toUnstructured(identity)
+	if err != nil {
+		return result, err
+	}
+	current := map[string]*unstructured.Unstructured{}
+	for _, credential := range existing {
+		u, err := toUnstructured(credential)
+		if err != nil {
+			return result, err
+		}
+		current[credential.GetName()] = u
+	}
+
+	wanted := map[string]bool{}
+	for _, sa := range serviceAccounts {
+		name := federatedCredentialName(identity.GetName(), sa)
+		wanted[name] = true
+		if u, ok := current[name]; ok && r.federatedCredentialCurrent(u, identityObject, sa) {
+			continue
+		}
+		if err := r.applyFederatedCredential(ctx, identity, name, sa); err != nil {
+			return result, err
+		}
+		log.Info("Applied FederatedIdentityCredential", "name", name, "ServiceAccount", sa)
+		result.UpdatedFederatedCredentials = append(result.UpdatedFederatedCredentials, name)
+	}
+
+	for _, credential := range existing {
+		if wanted[credential.GetName()] {
+			continue
+		}
+		if err := r.Delete(ctx, credential); client.IgnoreNotFound(err) != nil {
+			return result, err
+		}
+		log.Info("Pruned FederatedIdentityCredential", "name", credential.GetName())
+		r.event(identity, corev1.EventTypeNormal, "FederatedCredentialPruned",
+			fmt.Sprintf("Deleted FederatedIdentityCredential %s for a ServiceAccount that no longer exists", credential.GetName()))
+		result.PrunedFederatedCredentials = append(result.PrunedFederatedCredentials, credential.GetName())
+	}
+	slices.Sort(result.PrunedFederatedCredentials)
+	return result, nil
+}
+
+// federatedCredentialCurrent reports whether the credential u trusts sa with the configured issuer
+// and uses the ProviderConfig of identity.
+func (r *UserAssignedIdentityReconciler) federatedCredentialCurrent(u, identity *unstructured.Unstructured, sa types.NamespacedName) bool {
+	providerConfig, _, _ := unstructured.NestedFieldNoCopy(u.Object, "spec", "providerConfigRef")
+	wantProviderConfig, _, _ := unstructured.NestedFieldNoCopy(identity.Object, "spec", "providerConfigRef")
+	if wantProviderConfig != nil && !equality.Semantic.DeepEqual(providerConfig, wantProviderConfig) {
+		return false
+	}
+	issuer, _, _ := unstructured.NestedString(u.Object, "spec", "forProvider", "issuer")
+	subject, _, _ := unstructured.NestedString(u.Object, "spec", "forProvider", "subject")
+	audience, _, _ := unstructured.NestedStringSlice(u.Object, "spec", "forProvider", "audience")
+	return issuer == r.OIDCIssuerURL && subject == federatedCredentialSubject(sa) && slices.Equal(audience, []string{federatedCredentialAudience})
+}
+
+// applyFederatedCredential applies the credential named name trusting sa, in the scope and
+// namespace of identity, owned by identity, in its resource group and managed through its
+// ProviderConfig.
+func (r *UserAssignedIdentityReconciler) applyFederatedCredential(ctx context.Context, identity client.Object, name string, sa types.NamespacedName) error {
+	var credential client.Object = &mi.FederatedIdentityCredential{}
+	if identity.GetNamespace() == "" {
+		credential = &mi2.FederatedIdentityCredential{}
+	}
+	gvk, err := r.GroupVersionKindFor(credential)
+	if err != nil {
+		return err
+	}
+	identityObject, err := toUnstructured(identity)
+	if err != nil {
+		return err
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	u.SetName(name)
+	u.SetNamespace(identity.GetNamespace())
+	u.SetLabels(map[string]string{federatedCredentialIdentityLabel: federatedCredentialIdentity(identity.GetName()), managedByLabel: FieldManager})
+	if err := controllerutil.SetControllerReference(identity, u, r.Scheme); err != nil {
+		return err
+	}
+	forProvider := map[string]any{
+		"issuer":      r.OIDCIssuerURL,
+		"subject":     federatedCredentialSubject(sa),
+		"audience":    []any{federatedCredentialAudience},
+		"parentIdRef": map[string]any{"name": identity.GetName()},
+	}
+	// The credential lives in the resource group of its identity
+	for _, field := range []string{"resourceGroupName", "resourceGroupNameRef", "resourceGroupNameSelector"} {
+		if value, ok, _ := unstructured.NestedFieldCopy(identityObject.Object, "spec", "forProvider", field); ok {
+			forProvider[field] = value
+		}
+	}
+	if err := unstructured.SetNestedMap(u.Object, forProvider, "spec", "forProvider"); err != nil {
+		return err
+	}
+	if providerConfig, ok, _ := unstructured.NestedFieldCopy(identityObject.Object, "spec", "providerConfigRef"); ok {
+		if err := unstructured.SetNestedField(u.Object, providerConfig, "spec", "providerConfigRef"); err != nil {
+			return err
+		}
+	}
+	credential.SetName(name)
+	credential.SetNamespace(identity.GetNamespace())
+	return r.apply(ctx, credential, [][]string{{"spec", "forProvider"}}, identity, func(resourceVersion string) runtime.ApplyConfiguration {
+		config := u.DeepCopy()
+		config.SetResourceVersion(resourceVersion)
+		return client.ApplyConfigurationFromUnstructured(config)
+	})
+}
+
+func toUnstructured(obj client.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const testIssuer = "https://oidc.example.com/cluster"
+
+func TestFederatedCredentialName(t *testing.T) {
+	sa := types.NamespacedName{Name: "workload-identity-testapp", Namespace: "team.a"}
+	if got, want := federatedCredentialName("testapp", sa), "testapp-team-a-workload-identity-testapp"; got != want {
+		t.Errorf("federatedCredentialName() = %s, want %s", got, want)
+	}
+
+	long := types.NamespacedName{Name: strings.Repeat("s", 100), Namespace: "default"}
+	name := federatedCredentialName(strings.Repeat("i", 60), long)
+	if len(name) != maxFederatedCredentialName {
+		t.Errorf("Expected a name of %d characters, got %d", maxFederatedCredentialName, len(name))
+	}
+	other := federatedCredentialName(strings.Repeat("i", 60), types.NamespacedName{Name: long.Name + "x", Namespace: "default"})
+	if name == other {
+		t.Errorf("Expected distinct names for distinct ServiceAccounts, got %s twice", name)
+	}
+}
+
+func TestSyncFederatedCredentials(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Spec.ForProvider.ResourceGroupName = ptr.To("rg-testapp")
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	serviceAccount := func(name, namespace string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: namespace, Labels: map[string]string{ServiceAccountAppLabel: "testapp"},
+		}}
+	}
+	objs := []client.Object{
+		identity,
+		serviceAccount("workload-identity-testapp", "default"),
+		serviceAccount("runner", "team-a"),
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+	r := &UserAssignedIdentityReconciler{
+		Client:        cl,
+		Scheme:        s,
+		Log:           zap.New(zap.UseDevMode(true)),
+		Recorder:      record.NewFakeRecorder(20),
+		WorkloadKinds: []string{},
+		OIDCIssuerURL: testIssuer,
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	reconcile := func() {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+		outOfSyncIdentities.forget(key)
+	}
+	credentials := func() map[string]mi.FederatedIdentityCredential {
+		t.Helper()
+		var list mi.FederatedIdentityCredentialList
+		if err := cl.List(ctx, &list); err != nil {
+			t.Fatalf("Failed to list FederatedIdentityCredentials: %v", err)
+		}
+		byName := map[string]mi.FederatedIdentityCredential{}
+		for _, credential := range list.Items {
+			byName[credential.Name] = credential
+		}
+		return byName
+	}
+
+	reconcile()
+	got := credentials()
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 FederatedIdentityCredentials, got %d", len(got))
+	}
+	credential, ok := got["testapp-team-a-runner"]
+	if !ok {
+		t.Fatalf("FederatedIdentityCredential testapp-team-a-runner missing, got %v", got)
+	}
+	p := credential.Spec.ForProvider
+	if ptr.Deref(p.Issuer, "") != testIssuer {
+		t.Errorf("Issuer incorrect. Expected %s, got %s", testIssuer, ptr.Deref(p.Issuer, ""))
+	}
+	if ptr.Deref(p.Subject, "") != "system:serviceaccount:team-a:runner" {
+		t.Errorf("Subject incorrect. Expected system:serviceaccount:team-a:runner, got %s", ptr.Deref(p.Subject, ""))
+	}
+	if len(p.Audience) != 1 || ptr.Deref(p.Audience[0], "") != federatedCredentialAudience {
+		t.Errorf("Audience incorrect. Expected %s, got %v", federatedCredentialAudience, p.Audience)
+	}
+	if ptr.Deref(p.ResourceGroupName, "") != "rg-testapp" {
+		t.Errorf("Resource group incorrect. Expected rg-testapp, got %s", ptr.Deref(p.ResourceGroupName, ""))
+	}
+	if p.ParentIDRef == nil || p.ParentIDRef.Name != "testapp" {
+		t.Errorf("Parent reference incorrect. Expected testapp, got %v", p.ParentIDRef)
+	}
+	if len(credential.OwnerReferences) != 1 || credential.OwnerReferences[0].Name != "testapp" {
+		t.Errorf("Owner references incorrect: %v", credential.OwnerReferences)
+	}
+
+	// Nothing changes while the credentials are current
+	sync, err := r.syncFederatedCredentials(ctx, identity, []types.NamespacedName{
+		{Name: "workload-identity-testapp", Namespace: "default"}, {Name: "runner", Namespace: "team-a"},
+	}, r.Log)
+	if err != nil {
+		t.Fatalf("syncFederatedCredentials() error = %v", err)
+	}
+	if len(sync.UpdatedFederatedCredentials) != 0 || len(sync.PrunedFederatedCredentials) != 0 {
+		t.Errorf("Expected no changes, got %+v", sync)
+	}
+
+	// The credential of a deleted ServiceAccount is pruned
+	if err := cl.Delete(ctx, serviceAccount("runner", "team-a")); err != nil {
+		t.Fatalf("Failed to delete ServiceAccount: %v", err)
+	}
+	reconcile()
+	var names []string
+	for name := range credentials() {
+		names = append(names, name)
+	}
+	if want := []string{"testapp-default-workload-identity-testapp"}; !slices.Equal(names, want) {
+		t.Errorf("FederatedIdentityCredentials incorrect. Expected %v, got %v", want, names)
+	}
+}
+
+func TestSyncFederatedCredentials_Disabled(t *testing.T) {
+	r := &UserAssignedIdentityReconciler{Log: zap.New(zap.UseDevMode(true))}
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	sync, err := r.syncFederatedCredentials(context.Background(), identity, []types.NamespacedName{{Name: "sa", Namespace: "default"}}, r.Log)
+	if err != nil || len(sync.UpdatedFederatedCredentials) != 0 {
+		t.Errorf("Expected nothing to happen without an issuer URL, got %+v, %v", sync, err)
+	}
+}
+
+func TestSyncFederatedCredentials_LongNameAndProviderConfig(t *testing.T) {
+	s := scheme.Scheme
+	_ = mi.AddToScheme(s)
+
+	name := strings.Repeat("a", 70)
+	identity := identityNamed(name, "default", "id-service-testapp-dv-azunea-001")
+	if err := json.Unmarshal([]byte(`{"spec":{"providerConfigRef":{"kind":"ProviderConfig","name":"team-a"}}}`), identity); err != nil {
+		t.Fatalf("Failed to set the ProviderConfig: %v", err)
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(identity).Build()
+	r := &UserAssignedIdentityReconciler{
+		Client:        cl,
+		Scheme:        s,
+		Log:           zap.New(zap.UseDevMode(true)),
+		Recorder:      record.NewFakeRecorder(20),
+		OIDCIssuerURL: testIssuer,
+	}
+	ctx := context.Background()
+	serviceAccounts := []types.NamespacedName{{Name: "runner", Namespace: "default"}}
+	if _, err := r.syncFederatedCredentials(ctx, identity, serviceAccounts, r.Log); err != nil {
+		t.Fatalf("syncFederatedCredentials() error = %v", err)
+	}
+
+	var list mi.FederatedIdentityCredentialList
+	if err := cl.List(ctx, &list); err != nil || len(list.Items) != 1 {
+		t.Fatalf("Expected 1 FederatedIdentityCredential, got %d, %v", len(list.Items), err)
+	}
+	credential := list.Items[0]
+	if label := credential.Labels[federatedCredentialIdentityLabel]; len(label) > maxLabelValue {
+		t.Errorf("Expected a label value of at most %d characters, got %q", maxLabelValue, label)
+	}
+	if ref := credential.Spec.ProviderConfigReference; ref == nil || ref.Name != "team-a" {
+		t.Errorf("Expected the identity's ProviderConfig team-a, got %v", ref)
+	}
+
+	// The shortened label still finds the credential, so nothing changes
+	sync, err := r.syncFederatedCredentials(ctx, identity, serviceAccounts, r.Log)
+	if err != nil {
+		t.Fatalf("syncFederatedCredentials() error = %v", err)
+	}
+	if len(sync.UpdatedFederatedCredentials) != 0 || len(sync.PrunedFederatedCredentials) != 0 {
+		t.Errorf("Expected no changes, got %+v", sync)
+	}
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// identityAppIndex indexes UserAssignedIdentities of both scopes by their app name.
+const identityAppIndex = "clientid-operator.identity-app"
+
+// indexIdentityApp is the field index function for identityAppIndex. Identities whose
+// app name cannot be extracted are not indexed.
+func (r *UserAssignedIdentityReconciler) indexIdentityApp(obj client.Object) []string {
+	appName, err := r.extractAppName(obj)
+	if err != nil {
+		return nil
+	}
+	return []string{appName}
+}
+
+// bindingIdentityIndex indexes IdentityBindings by the namespace/name of the identity they reference.
+const bindingIdentityIndex = "spec.identityRef"
+
+// indexBindingIdentity is the field index function for bindingIdentityIndex.
+func indexBindingIdentity(obj client.Object) []string {
+	ref := obj.(*identityv1alpha1.IdentityBinding).Spec.IdentityRef
+	return []string{types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}.String()}
+}
+
+// identitiesForApp returns a request for every identity of either scope belonging to appName.
+// Cluster-scoped identities are enqueued without a namespace, like their own watch does.
+func (r *UserAssignedIdentityReconciler) identitiesForApp(ctx context.Context, appName string) []reconcile.Request {
+	log := r.Log.WithValues("app", appName)
+
+	var requests []reconcile.Request
+	var namespaced mi.UserAssignedIdentityList
+	if err := r.List(ctx, &namespaced, client.MatchingFields{identityAppIndex: appName}); err != nil {
+		log.Error(err, "Error listing namespaced UserAssignedIdentities")
+	}
+	for _, identity := range namespaced.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&identity)})
+	}
+
+	var cluster mi2.UserAssignedIdentityList
+	if err := r.List(ctx, &cluster, client.MatchingFields{identityAppIndex: appName}); err != nil {
+		log.Error(err, "Error listing cluster-scoped UserAssignedIdentities")
+	}
+	for _, identity := range cluster.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: identity.Name}})
+	}
+	return requests
+}
+
+// identityForBinding maps an IdentityBinding to the identity it references, enqueued like
+// the identity's own watch does.
+func identityForBinding(_ context.Context, obj client.Object) []reconcile.Request {
+	ref := obj.(*identityv1alpha1.IdentityBinding).Spec.IdentityRef
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}}}
+}
+
+// identitiesForServiceAccount maps a ServiceAccount to the identities of the app it belongs to.
+func (r *UserAssignedIdentityReconciler) identitiesForServiceAccount(ctx context.Context, obj client.Object) []reconcile.Request {
+	apps := serviceAccountApp(obj)
+	if len(apps) == 0 {
+		return nil
+	}
+	if inScope, err := r.inScope(ctx, client.ObjectKeyFromObject(obj)); err != nil || !inScope {
+		return nil
+	}
+	return r.identitiesForApp(ctx, apps[0])
+}
+
+// identitiesForRoleAssignment maps a RoleAssignment of either scope to the identities of the
+// app named in its `application` label.
+func (r *UserAssignedIdentityReconciler) identitiesForRoleAssignment(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	if labels["type"] != "roleassignment" || labels["application"] == "" {
+		return nil
+	}
+	return r.identitiesForApp(ctx, labels["application"])
+}
+
+// conflictingIdentities returns the other identities of either scope resolving to appName,
+// leaving out those bound by an IdentityBinding, which no longer use the naming convention.
+func (r *UserAssignedIdentityReconciler) conflictingIdentities(ctx context.Context, identity client.Object, appName string) ([]string, error) {
+	var conflicts []string
+	for _, req := range r.identitiesForApp(ctx, appName) {
+		if req.NamespacedName == client.ObjectKeyFromObject(identity) {
+			continue
+		}
+		if r.IdentityBindings {
+			bound, err := r.isBound(ctx, req.NamespacedName)
+			if err != nil {
+				return nil, err
+			}
+			if bound {
+				continue
+			}
+		}
+		name := req.Name
+		if req.Namespace != "" {
+			name = req.String()
+		}
+		conflicts = append(conflicts, name)
+	}
+	return conflicts, nil
+}
+
+// setupIdentityIndexes registers identityAppIndex for both identity scopes, and
+// bindingIdentityIndex when IdentityBindings are enabled.
+func (r *UserAssignedIdentityReconciler) setupIdentityIndexes(mgr ctrl.Manager) error {
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(context.Background(), &mi.UserAssignedIdentity{}, identityAppIndex, r.indexIdentityApp); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(context.Background(), &mi2.UserAssignedIdentity{}, identityAppIndex, r.indexIdentityApp); err != nil {
+		return err
+	}
+	if !r.IdentityBindings {
+		return nil
+	}
+	return indexer.IndexField(context.Background(), &identityv1alpha1.IdentityBinding{}, bindingIdentityIndex, indexBindingIdentity)
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func identityNamed(name, namespace, azureName string) *mi.UserAssignedIdentity {
+	return &mi.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       mi.UserAssignedIdentitySpec{ForProvider: mi.UserAssignedIdentityParameters{Name: &azureName}},
+	}
+}
+
+func TestIdentitiesForWatchedObjects(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+
+	clusterName := "id-service-testapp-dv-azunea-002"
+	objs := []client.Object{
+		identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001"),
+		identityNamed("otherapp", "default", "id-service-otherapp-dv-azunea-001"),
+		&mi2.UserAssignedIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "testapp-cluster"},
+			Spec:       mi2.UserAssignedIdentitySpec{ForProvider: mi2.UserAssignedIdentityParameters{Name: &clusterName}},
+		},
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+	ctx := context.Background()
+
+	testapp := []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "testapp", Namespace: "default"}},
+		{NamespacedName: types.NamespacedName{Name: "testapp-cluster"}},
+	}
+	tests := []struct {
+		name string
+		got  func() []reconcile.Request
+		want []reconcile.Request
+	}{
+		{
+			name: "ServiceAccount by name",
+			got: func() []reconcile.Request {
+				return r.identitiesForServiceAccount(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+					Name: "workload-identity-testapp", Namespace: "team-a",
+				}})
+			},
+			want: testapp,
+		},
+		{
+			name: "ServiceAccount by label",
+			got: func() []reconcile.Request {
+				return r.identitiesForServiceAccount(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+					Name: "runner", Namespace: "team-a", Labels: map[string]string{ServiceAccountAppLabel: "otherapp"},
+				}})
+			},
+			want: []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "otherapp", Namespace: "default"}}},
+		},
+		{
+			name: "unrelated ServiceAccount",
+			got: func() []reconcile.Request {
+				return r.identitiesForServiceAccount(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "team-a"}})
+			},
+		},
+		{
+			name: "RoleAssignment",
+			got: func() []reconcile.Request {
+				return r.identitiesForRoleAssignment(ctx, &ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{
+					Name: "ra-testapp", Namespace: "default", Labels: map[string]string{"application": "testapp", "type": "roleassignment"},
+				}})
+			},
+			want: testapp,
+		},
+		{
+			name: "RoleAssignment without type label",
+			got: func() []reconcile.Request {
+				return r.identitiesForRoleAssignment(ctx, &ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{
+					Name: "ra-testapp", Namespace: "default", Labels: map[string]string{"application": "testapp"},
+				}})
+			},
+		},
+		{
+			name: "IdentityBinding of a namespaced identity",
+			got: func() []reconcile.Request {
+				return identityForBinding(ctx, &identityv1alpha1.IdentityBinding{Spec: identityv1alpha1.IdentityBindingSpec{
+					IdentityRef: identityv1alpha1.IdentityReference{Name: "testapp", Namespace: "default"},
+				}})
+			},
+			want: testapp[:1],
+		},
+		{
+			name: "IdentityBinding of a cluster-scoped identity",
+			got: func() []reconcile.Request {
+				return identityForBinding(ctx, &identityv1alpha1.IdentityBinding{Spec: identityv1alpha1.IdentityBindingSpec{
+					IdentityRef: identityv1alpha1.IdentityReference{Name: "testapp-cluster"},
+				}})
+			},
+			want: testapp[1:],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.got(); !slices.Equal(got, tt.want) {
+				t.Errorf("Requests incorrect. Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIdentitiesForServiceAccount_OutOfScope(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")).Build()
+	r := &UserAssignedIdentityReconciler{
+		Client:     cl,
+		Scheme:     s,
+		Log:        zap.New(zap.UseDevMode(true)),
+		Namespaces: NamespaceScope{Exclude: []string{"kube-system"}},
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: "kube-system"}}
+	if got := r.identitiesForServiceAccount(context.Background(), sa); len(got) != 0 {
+		t.Errorf("Expected no requests for a ServiceAccount out of scope, got %v", got)
+	}
+}
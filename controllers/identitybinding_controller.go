@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+// IdentityBindingReconciler propagates identities to the ServiceAccounts and RoleAssignments
+// listed in an IdentityBinding, using the same engine as the naming convention.
+type IdentityBindingReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+
+	// Identities performs the ServiceAccount and RoleAssignment updates.
+	Identities *UserAssignedIdentityReconciler
+}
+
+func (r *IdentityBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("identitybinding", req.Name)
+
+	var binding identityv1alpha1.IdentityBinding
+	if err := r.Get(ctx, req.NamespacedName, &binding); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Error fetching IdentityBinding")
+		return ctrl.Result{}, err
+	}
+
+	identityKey := types.NamespacedName{Name: binding.Spec.IdentityRef.Name, Namespace: binding.Spec.IdentityRef.Namespace}
+	identity, clientID, principalID, tenantID, err := r.boundIdentity(ctx, binding.Spec.IdentityRef)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Referenced UserAssignedIdentity not found, skipping update.", "identity", binding.Spec.IdentityRef)
+			r.Identities.event(&binding, corev1.EventTypeWarning, "IdentityNotFound", err.Error())
+			outOfSyncIdentities.forget(identityKey)
+			identityStates.forget(identityKey)
+			return r.setReady(ctx, &binding, metav1.ConditionFalse, "IdentityNotFound", err.Error(), ctrl.Result{RequeueAfter: 5 * time.Minute})
+		}
+		log.Error(err, "Error fetching referenced UserAssignedIdentity")
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcileBinding(ctx, &binding, identity, clientID, principalID, tenantID, log)
+	identityStates.reconciled(identityKey, err)
+	return result, err
+}
+
+// reconcileBinding propagates the IDs of the bound identity to the dependents of binding and
+// records the outcome for the introspection endpoint of the identity.
+func (r *IdentityBindingReconciler) reconcileBinding(ctx context.Context, binding *identityv1alpha1.IdentityBinding, identity client.Object, clientID, principalID, tenantID string, log logr.Logger) (ctrl.Result, error) {
+	identityKey := client.ObjectKeyFromObject(identity)
+	log.Info("Fetched bound UserAssignedIdentity", "clientID", clientID, "principalID", principalID)
+
+	if clientID == "" || principalID == "" {
+		log.Info("Missing critical ID information, skipping update.")
+		r.Identities.event(binding, corev1.EventTypeWarning, syncResultMissingIDs, "UserAssignedIdentity has no client or principal ID yet, skipping update")
+		identitiesSkippedTotal.WithLabelValues(skipReasonMissingIDs).Inc()
+		outOfSyncIdentities.set(identityKey, true)
+		identityStates.setResult(identityKey, syncResultMissingIDs, syncSummary{})
+		return r.setReady(ctx, binding, metav1.ConditionFalse, syncResultMissingIDs, "UserAssignedIdentity has no client or principal ID yet", ctrl.Result{RequeueAfter: 5 * time.Minute})
+	}
+
+	serviceAccounts, err := r.Identities.boundServiceAccounts(ctx, binding)
+	if err != nil {
+		log.Error(err, "Failed to resolve bound ServiceAccounts")
+		identityStates.setResult(identityKey, "InvalidServiceAccountSelector", syncSummary{})
+		return r.setReady(ctx, binding, metav1.ConditionFalse, "InvalidServiceAccountSelector", err.Error(), ctrl.Result{})
+	}
+
+	var summary syncSummary
+	summary.serviceAccountSync, err = r.Identities.updateServiceAccounts(ctx, serviceAccounts, workloadIdentity{ClientID: clientID, TenantID: tenantID, Owner: binding}, log)
+	if err != nil {
+		log.Error(err, "Failed to update ServiceAccounts")
+		r.Identities.event(binding, corev1.EventTypeWarning, "ServiceAccountUpdateFailed", fmt.Sprintf("Failed to update ServiceAccounts: %v", err))
+		outOfSyncIdentities.set(identityKey, true)
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
+	}
+
+	if binding.Spec.RoleAssignmentSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(binding.Spec.RoleAssignmentSelector)
+		if err != nil {
+			log.Error(err, "Invalid RoleAssignment selector")
+			identityStates.setResult(identityKey, "InvalidRoleAssignmentSelector", syncSummary{})
+			return r.setReady(ctx, binding, metav1.ConditionFalse, "InvalidRoleAssignmentSelector", err.Error(), ctrl.Result{})
+		}
+		summary.roleAssignmentSync, err = r.Identities.updateRoleAssignments(ctx, selector, allRoleAssignments, principalID, log)
+		if err != nil {
+			log.Error(err, "Failed to update RoleAssignments")
+			r.Identities.event(binding, corev1.EventTypeWarning, "RoleAssignmentUpdateFailed", fmt.Sprintf("Failed to update RoleAssignments: %v", err))
+			outOfSyncIdentities.set(identityKey, true)
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, err
+		}
+	}
+
+	// Credentials are kept per identity, so they trust the ServiceAccounts of all its bindings
+	subjects, err := r.Identities.bindingSubjects(ctx, identityKey)
+	if err != nil {
+		log.Error(err, "Failed to resolve the ServiceAccounts bound to the identity")
+		return ctrl.Result{}, err
+	}
+	summary.federatedCredentialSync, err = r.Identities.syncFederatedCredentials(ctx, identity, subjects, log)
+	if err != nil {
+		log.Error(err, "Failed to sync FederatedIdentityCredentials")
+		r.Identities.event(binding, corev1.EventTypeWarning, "FederatedCredentialSyncFailed", fmt.Sprintf("Failed to sync FederatedIdentityCredentials: %v", err))
+		outOfSyncIdentities.set(identityKey, true)
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
+	}
+	if summary.changed() {
+		r.Identities.event(binding, corev1.EventTypeNormal, syncResultSynced, summary.String())
+	}
+
+	binding.Status.ClientID = clientID
+	binding.Status.PrincipalID = principalID
+	result := ctrl.Result{RequeueAfter: r.Identities.ResyncPeriod}
+	if len(summary.PatchedServiceAccounts) > 0 || len(summary.UpdatedRoleAssignments) > 0 || len(summary.UpdatedFederatedCredentials) > 0 {
+		result = r.Identities.recheck(log)
+	}
+	result = summary.requeueResult(result)
+	outOfSyncIdentities.set(identityKey, len(summary.FailedRoleAssignments) > 0)
+	if len(summary.FailedRoleAssignments) > 0 {
+		identityStates.setResult(identityKey, syncResultRoleAssignmentUpdateFailed, summary)
+		message := fmt.Sprintf("Failed to update RoleAssignment(s) %s", strings.Join(summary.FailedRoleAssignments, ", "))
+		r.Identities.event(binding, corev1.EventTypeWarning, syncResultRoleAssignmentUpdateFailed, message)
+		return r.setReady(ctx, binding, metav1.ConditionFalse, syncResultRoleAssignmentUpdateFailed, message, result)
+	}
+	identityStates.setResult(identityKey, syncResultSynced, summary)
+	return r.setReady(ctx, binding, metav1.ConditionTrue, syncResultSynced, "ServiceAccounts and RoleAssignments match the identity", result)
+}
+
+// boundIdentity returns the referenced identity and its client, principal and tenant IDs. An
+// empty namespace refers to a cluster-scoped identity.
+func (r *IdentityBindingReconciler) boundIdentity(ctx context.Context, ref identityv1alpha1.IdentityReference) (client.Object, string, string, string, error) {
+	key := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+	if ref.Namespace == "" {
+		var identity mi2.UserAssignedIdentity
+		if err := r.Get(ctx, key, &identity); err != nil {
+			return nil, "", "", "", err
+		}
+		ids := identity.Status.AtProvider
+		return &identity, ptr.Deref(ids.ClientID, ""), ptr.Deref(ids.PrincipalID, ""), ptr.Deref(ids.TenantID, ""), nil
+	}
+	var identity mi.UserAssignedIdentity
+	if err := r.Get(ctx, key, &identity); err != nil {
+		return nil, "", "", "", err
+	}
+	ids := identity.Status.AtProvider
+	return &identity, ptr.Deref(ids.ClientID, ""), ptr.Deref(ids.PrincipalID, ""), ptr.Deref(ids.TenantID, ""), nil
+}
+
+// boundServiceAccounts returns the explicitly listed ServiceAccounts plus those matched by the selector.
+func (r *UserAssignedIdentityReconciler) boundServiceAccounts(ctx context.Context, binding *identityv1alpha1.IdentityBinding) ([]types.NamespacedName, error) {
+	seen := map[types.NamespacedName]bool{}
+	var keys []types.NamespacedName
+	for _, ref := range binding.Spec.ServiceAccounts {
+		key := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if binding.Spec.ServiceAccountSelector == nil {
+		return keys, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(binding.Spec.ServiceAccountSelector)
+	if err != nil {
+		return nil, err
+	}
+	var serviceAccounts corev1.ServiceAccountList
+	if err := r.List(ctx, &serviceAccounts, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	for _, sa := range serviceAccounts.Items {
+		key := types.NamespacedName{Name: sa.Name, Namespace: sa.Namespace}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// identityBindings returns the IdentityBindings referencing the identity key.
+func (r *UserAssignedIdentityReconciler) identityBindings(ctx context.Context, key types.NamespacedName) ([]identityv1alpha1.IdentityBinding, error) {
+	var bindings identityv1alpha1.IdentityBindingList
+	if err := r.List(ctx, &bindings, client.MatchingFields{bindingIdentityIndex: key.String()}); err != nil {
+		return nil, err
+	}
+	return bindings.Items, nil
+}
+
+// bindingSubjects returns the existing, in-scope ServiceAccounts bound to the identity key by
+// any of its IdentityBindings.
+func (r *UserAssignedIdentityReconciler) bindingSubjects(ctx context.Context, key types.NamespacedName) ([]types.NamespacedName, error) {
+	bindings, err := r.identityBindings(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[types.NamespacedName]bool{}
+	var subjects []types.NamespacedName
+	for i := range bindings {
+		serviceAccounts, err := r.boundServiceAccounts(ctx, &bindings[i])
+		if err != nil {
+			// Reported on the binding with the invalid selector
+			continue
+		}
+		for _, sa := range serviceAccounts {
+			if seen[sa] {
+				continue
+			}
+			seen[sa] = true
+			inScope, err := r.inScope(ctx, sa)
+			if err != nil {
+				return nil, err
+			}
+			if !inScope {
+				continue
+			}
+			if err := r.Get(ctx, sa, &corev1.ServiceAccount{}); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			subjects = append(subjects, sa)
+		}
+	}
+	return subjects, nil
+}
+
+// setReady records the Ready condition on the binding and returns result once the status is written.
+func (r *IdentityBindingReconciler) setReady(ctx context.Context, binding *identityv1alpha1.IdentityBinding, status metav1.ConditionStatus, reason, message string, result ctrl.Result) (ctrl.Result, error) {
+	binding.Status.ObservedGeneration = binding.Generation
+	meta.SetStatusCondition(&binding.Status.Conditions, metav1.Condition{
+		Type:               "Ready",
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: binding.Generation,
+	})
+	if err := r.Status().Update(ctx, binding); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// bindingsForIdentity maps a UserAssignedIdentity to the IdentityBindings that reference it.
+func (r *IdentityBindingReconciler) bindingsForIdentity(ctx context.Context, obj client.Object) []reconcile.Request {
+	var bindings identityv1alpha1.IdentityBindingList
+	if err := r.List(ctx, &bindings, client.MatchingFields{bindingIdentityIndex: client.ObjectKeyFromObject(obj).String()}); err != nil {
+		r.Log.Error(err, "Error listing IdentityBindings")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, binding := range bindings.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: binding.Name}})
+	}
+	return requests
+}
+
+func (r *IdentityBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&identityv1alpha1.IdentityBinding{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&mi.UserAssignedIdentity{}, handler.EnqueueRequestsFromMapFunc(r.bindingsForIdentity)).
+		Watches(&mi2.UserAssignedIdentity{}, handler.EnqueueRequestsFromMapFunc(r.bindingsForIdentity)).
+		Complete(r)
+}
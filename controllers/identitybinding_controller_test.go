@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+func TestIdentityBindingReconciler_Reconcile(t *testing.T) {
+	// Register schemes
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = ra2.AddToScheme(s)
+	_ = identityv1alpha1.AddToScheme(s)
+
+	// Test data, deliberately not following the naming convention
+	namespace := "payments"
+	identityName := "payments-api-identity"
+	clientID := "binding-client-id"
+	principalID := "binding-principal-id"
+	oldPrincipalID := "old-principal-id"
+
+	// 1. UserAssignedIdentity
+	namePtr := identityName
+	identity := &mi.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: identityName, Namespace: namespace},
+		Spec: mi.UserAssignedIdentitySpec{
+			ForProvider: mi.UserAssignedIdentityParameters{Name: &namePtr},
+		},
+		Status: mi.UserAssignedIdentityStatus{
+			AtProvider: mi.UserAssignedIdentityObservation{
+				ClientID:    &clientID,
+				PrincipalID: &principalID,
+			},
+		},
+	}
+
+	// 2. ServiceAccounts: one referenced by name, one by selector, one unrelated
+	namedSA := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments-api", Namespace: namespace},
+	}
+	selectedSA := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "payments-worker",
+			Namespace: "payments-jobs",
+			Labels:    map[string]string{"team": "payments"},
+		},
+	}
+	unrelatedSA := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: namespace},
+	}
+
+	// 3. RoleAssignment matched by the binding's selector
+	roleAssignment := &ra.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "payments-storage-reader",
+			Namespace: namespace,
+			Labels:    map[string]string{"team": "payments"},
+		},
+		Spec: ra.RoleAssignmentSpec{
+			ForProvider: ra.RoleAssignmentParameters{PrincipalID: &oldPrincipalID},
+		},
+	}
+
+	// 4. IdentityBinding
+	binding := &identityv1alpha1.IdentityBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+		Spec: identityv1alpha1.IdentityBindingSpec{
+			IdentityRef: identityv1alpha1.IdentityReference{Name: identityName, Namespace: namespace},
+			ServiceAccounts: []identityv1alpha1.ServiceAccountReference{
+				{Name: "payments-api", Namespace: namespace},
+			},
+			ServiceAccountSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			RoleAssignmentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		},
+	}
+
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(identity, namedSA, selectedSA, unrelatedSA, roleAssignment, binding).
+		WithStatusSubresource(binding).
+		Build()
+
+	log := zap.New(zap.UseDevMode(true))
+	identities := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: log, IdentityBindings: true}
+	r := &IdentityBindingReconciler{Client: cl, Scheme: s, Log: log, Identities: identities}
+
+	ctx := context.Background()
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "payments"}}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	// Verify bound ServiceAccounts were annotated and the unrelated one was not
+	for _, key := range []types.NamespacedName{
+		{Name: "payments-api", Namespace: namespace},
+		{Name: "payments-worker", Namespace: "payments-jobs"},
+	} {
+		sa := &corev1.ServiceAccount{}
+		if err := cl.Get(ctx, key, sa); err != nil {
+			t.Fatalf("Failed to get ServiceAccount %s: %v", key, err)
+		}
+		if val := sa.Annotations["azure.workload.identity/client-id"]; val != clientID {
+			t.Errorf("ServiceAccount %s annotation incorrect. Expected %s, got %s", key, clientID, val)
+		}
+	}
+	other := &corev1.ServiceAccount{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: "other", Namespace: namespace}, other); err != nil {
+		t.Fatalf("Failed to get ServiceAccount: %v", err)
+	}
+	if _, ok := other.Annotations["azure.workload.identity/client-id"]; ok {
+		t.Error("Unbound ServiceAccount was annotated")
+	}
+
+	// Verify RoleAssignment Update
+	updatedRA := &ra.RoleAssignment{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: "payments-storage-reader", Namespace: namespace}, updatedRA); err != nil {
+		t.Fatalf("Failed to get RoleAssignment: %v", err)
+	}
+	if *updatedRA.Spec.ForProvider.PrincipalID != principalID {
+		t.Errorf("RoleAssignment PrincipalID incorrect. Expected %s, got %s", principalID, *updatedRA.Spec.ForProvider.PrincipalID)
+	}
+
+	// Verify binding status
+	updatedBinding := &identityv1alpha1.IdentityBinding{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: "payments"}, updatedBinding); err != nil {
+		t.Fatalf("Failed to get IdentityBinding: %v", err)
+	}
+	if updatedBinding.Status.ClientID != clientID {
+		t.Errorf("IdentityBinding status clientID incorrect. Expected %s, got %s", clientID, updatedBinding.Status.ClientID)
+	}
+	if !meta.IsStatusConditionTrue(updatedBinding.Status.Conditions, "Ready") {
+		t.Errorf("IdentityBinding not Ready: %+v", updatedBinding.Status.Conditions)
+	}
+
+	// The introspection endpoint reports the binding's sync on the identity
+	identityKey := types.NamespacedName{Name: identityName, Namespace: namespace}
+	defer identityStates.forget(identityKey)
+	if state, ok := identityStates.get(identityKey); !ok || state.Result != syncResultSynced || state.Summary == "" {
+		t.Errorf("Expected the sync to be recorded for the identity, got %+v", state)
+	}
+
+	// The naming convention must leave the bound identity alone
+	bound, err := identities.isBound(ctx, types.NamespacedName{Name: identityName, Namespace: namespace})
+	if err != nil {
+		t.Fatalf("isBound failed: %v", err)
+	}
+	if !bound {
+		t.Error("Identity referenced by an IdentityBinding not reported as bound")
+	}
+}
+
+func TestIdentityBindingReconciler_ReconcileMissingIdentity(t *testing.T) {
+	s := scheme.Scheme
+	_ = identityv1alpha1.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+
+	binding := &identityv1alpha1.IdentityBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "orphan"},
+		Spec: identityv1alpha1.IdentityBindingSpec{
+			IdentityRef: identityv1alpha1.IdentityReference{Name: "does-not-exist"},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(binding).WithStatusSubresource(binding).Build()
+
+	log := zap.New(zap.UseDevMode(true))
+	r := &IdentityBindingReconciler{
+		Client:     cl,
+		Scheme:     s,
+		Log:        log,
+		Identities: &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: log},
+	}
+
+	ctx := context.Background()
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "orphan"}}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	updatedBinding := &identityv1alpha1.IdentityBinding{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: "orphan"}, updatedBinding); err != nil {
+		t.Fatalf("Failed to get IdentityBinding: %v", err)
+	}
+	ready := meta.FindStatusCondition(updatedBinding.Status.Conditions, "Ready")
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "IdentityNotFound" {
+		t.Errorf("Expected Ready=False/IdentityNotFound, got %+v", ready)
+	}
+}
+
+// TestIdentityBindingReconciler_HandOver binds an identity the naming convention managed so far
+// and checks that what the convention set up outside the binding is released.
+func TestIdentityBindingReconciler_HandOver(t *testing.T) {
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = ra2.AddToScheme(s)
+	_ = identityv1alpha1.AddToScheme(s)
+
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	conventionSA := types.NamespacedName{Name: "workload-identity-testapp", Namespace: "default"}
+	boundSA := types.NamespacedName{Name: "payments-api", Namespace: "default"}
+	objs := []client.Object{
+		identity,
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: conventionSA.Name, Namespace: conventionSA.Namespace}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: boundSA.Name, Namespace: boundSA.Namespace}},
+		&ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{
+			Name: "ra-testapp", Namespace: "default", Labels: map[string]string{"application": "testapp", "type": "roleassignment"},
+		}},
+	}
+	binding := &identityv1alpha1.IdentityBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+		Spec: identityv1alpha1.IdentityBindingSpec{
+			IdentityRef:     identityv1alpha1.IdentityReference{Name: "testapp", Namespace: "default"},
+			ServiceAccounts: []identityv1alpha1.ServiceAccountReference{{Name: boundSA.Name, Namespace: boundSA.Namespace}},
+		},
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).WithStatusSubresource(binding).Build()
+	log := zap.New(zap.UseDevMode(true))
+	recorder := record.NewFakeRecorder(50)
+	identities := &UserAssignedIdentityReconciler{
+		Client:           cl,
+		Scheme:           s,
+		Log:              log,
+		Recorder:         recorder,
+		IdentityBindings: true,
+		WorkloadKinds:    []string{},
+		CleanupPolicy:    CleanupPolicyClear,
+		OIDCIssuerURL:    testIssuer,
+	}
+	r := &IdentityBindingReconciler{Client: cl, Scheme: s, Log: log, Identities: identities}
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	defer outOfSyncIdentities.forget(key)
+	credentialNames := func() []string {
+		t.Helper()
+		var list mi.FederatedIdentityCredentialList
+		if err := cl.List(ctx, &list); err != nil {
+			t.Fatalf("Failed to list FederatedIdentityCredentials: %v", err)
+		}
+		var names []string
+		for _, credential := range list.Items {
+			names = append(names, credential.Name)
+		}
+		return names
+	}
+
+	// The naming convention sets up the identity
+	if _, err := identities.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := credentialNames(); !slices.Equal(got, []string{federatedCredentialName("testapp", conventionSA)}) {
+		t.Fatalf("Expected the convention's FederatedIdentityCredential, got %v", got)
+	}
+
+	// Then the identity is bound to another ServiceAccount
+	if err := cl.Create(ctx, binding); err != nil {
+		t.Fatalf("Failed to create IdentityBinding: %v", err)
+	}
+	if _, err := identities.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "payments"}}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	var sa corev1.ServiceAccount
+	_ = cl.Get(ctx, conventionSA, &sa)
+	if got, ok := sa.Annotations[clientIDAnnotation]; ok {
+		t.Errorf("Expected the convention's ServiceAccount to be cleared, got client ID %s", got)
+	}
+	_ = cl.Get(ctx, boundSA, &sa)
+	if got := sa.Annotations[clientIDAnnotation]; got != "test-client-id" {
+		t.Errorf("Expected the bound ServiceAccount to be annotated, got client ID %q", got)
+	}
+	var roleAssignment ra.RoleAssignment
+	_ = cl.Get(ctx, types.NamespacedName{Name: "ra-testapp", Namespace: "default"}, &roleAssignment)
+	if roleAssignment.Annotations[pausedAnnotation] != "true" {
+		t.Errorf("Expected the convention's RoleAssignment to be paused under the clear policy, got %v", roleAssignment.Annotations)
+	}
+	if got := credentialNames(); !slices.Equal(got, []string{federatedCredentialName("testapp", boundSA)}) {
+		t.Errorf("Expected only the binding's FederatedIdentityCredential, got %v", got)
+	}
+	var current mi.UserAssignedIdentity
+	_ = cl.Get(ctx, key, &current)
+	if _, ok := current.Annotations[lastSyncResultAnnotation]; ok {
+		t.Errorf("Expected the convention's sync summary to be removed, got %v", current.Annotations)
+	}
+	if !slices.ContainsFunc(drainEvents(recorder), func(event string) bool { return strings.Contains(event, "HandedOver") }) {
+		t.Error("Expected a HandedOver event")
+	}
+}
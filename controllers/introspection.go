@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// syncResultError is the result reported for a reconcile that returned an error.
+const syncResultError = "Error"
+
+// ReconcileState is the outcome of the last reconcile of an identity.
+type ReconcileState struct {
+	Time time.Time `json:"time"`
+	// Result is the sync result of the last reconcile, as in the
+	// clientid-operator/last-sync-result annotation, or Error when it failed.
+	Result string `json:"result,omitempty"`
+	// Summary lists the changes made by the last sync that changed anything.
+	Summary string `json:"summary,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// reconcileTracker remembers the outcome of the last reconcile of each identity seen by
+// this replica.
+type reconcileTracker struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]ReconcileState
+}
+
+var identityStates = &reconcileTracker{states: map[types.NamespacedName]ReconcileState{}}
+
+// setResult records the sync result of the ongoing reconcile of the identity behind key.
+func (t *reconcileTracker) setResult(key types.NamespacedName, result string, summary syncSummary) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.states[key]
+	state.Result = result
+	if summary.changed() {
+		state.Summary = summary.String()
+	}
+	t.states[key] = state
+}
+
+// reconciled records that a reconcile of the identity behind key finished with err.
+func (t *reconcileTracker) reconciled(key types.NamespacedName, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.states[key]
+	state.Time = time.Now().UTC()
+	state.Error = ""
+	if err != nil {
+		state.Result = syncResultError
+		state.Error = err.Error()
+	} else if state.Result == syncResultError {
+		state.Result = ""
+	}
+	t.states[key] = state
+}
+
+// get returns the last reconcile of the identity behind key, if this replica saw one.
+func (t *reconcileTracker) get(key types.NamespacedName) (ReconcileState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.states[key]
+	return state, ok
+}
+
+// forget drops an identity that no longer exists.
+func (t *reconcileTracker) forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, key)
+}
+
+// IdentityStatus is the operator's view of an identity: its dependents, the outcome of
+// its last reconcile and what is still out of sync.
+type IdentityStatus struct {
+	IdentityReport
+	// LastReconcile is only known to the replica that reconciled the identity, i.e. the leader.
+	LastReconcile *ReconcileState `json:"lastReconcile,omitempty"`
+	// Drift lists the dependents that do not match the identity yet.
+	Drift []string `json:"drift,omitempty"`
+}
+
+// NewIntrospectionHandler serves the operator's view of the identities as JSON, read-only:
+// GET /identities lists every identity and GET /identities/{app} those of one app.
+func NewIntrospectionHandler(identities *UserAssignedIdentityReconciler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /identities", func(w http.ResponseWriter, req *http.Request) {
+		statuses, err := identityStatuses(identities, req, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, statuses)
+	})
+	mux.HandleFunc("GET /identities/{app}", func(w http.ResponseWriter, req *http.Request) {
+		app := req.PathValue("app")
+		statuses, err := identityStatuses(identities, req, app)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(statuses) == 0 {
+			http.Error(w, fmt.Sprintf("no UserAssignedIdentity with app name %s", app), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, statuses)
+	})
+	return mux
+}
+
+// identityStatuses returns the status of every identity, or only of those of app when set.
+func identityStatuses(r *UserAssignedIdentityReconciler, req *http.Request, app string) ([]IdentityStatus, error) {
+	reports, err := r.Inventory(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	statuses := []IdentityStatus{}
+	for _, report := range reports {
+		if app != "" && report.AppName != app {
+			continue
+		}
+		status := IdentityStatus{IdentityReport: report, Drift: identityDrift(report)}
+		if state, ok := identityStates.get(types.NamespacedName{Name: report.Name, Namespace: report.Namespace}); ok {
+			status.LastReconcile = &state
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// identityDrift describes the dependents of report that are not in sync with the identity.
+func identityDrift(report IdentityReport) []string {
+	var drift []string
+	for _, sa := range report.ServiceAccounts {
+		if !sa.Current {
+			drift = append(drift, fmt.Sprintf("ServiceAccount %s/%s has client ID %q", sa.Namespace, sa.Name, sa.ClientID))
+		}
+		if sa.PendingRestart {
+			drift = append(drift, fmt.Sprintf("ServiceAccount %s/%s has workloads pending a restart", sa.Namespace, sa.Name))
+		}
+	}
+	for _, roleAssignment := range report.RoleAssignments {
+		if !roleAssignment.Current {
+			name := types.NamespacedName{Name: roleAssignment.Name, Namespace: roleAssignment.Namespace}.String()
+			drift = append(drift, fmt.Sprintf("RoleAssignment %s has principal ID %q", name, roleAssignment.PrincipalID))
+		}
+	}
+	return drift
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
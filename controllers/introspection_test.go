@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReconcileTracker(t *testing.T) {
+	tracker := &reconcileTracker{states: map[types.NamespacedName]ReconcileState{}}
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+
+	tracker.setResult(key, syncResultSynced, syncSummary{serviceAccountSync: serviceAccountSync{PatchedServiceAccounts: []types.NamespacedName{key}}})
+	tracker.reconciled(key, nil)
+	state, ok := tracker.get(key)
+	if !ok || state.Result != syncResultSynced || !strings.HasPrefix(state.Summary, "Patched 1 ServiceAccount(s)") || state.Time.IsZero() {
+		t.Errorf("State after a sync incorrect: %+v", state)
+	}
+
+	tracker.reconciled(key, errors.New("list failed"))
+	if state, _ := tracker.get(key); state.Result != syncResultError || state.Error != "list failed" || state.Summary == "" {
+		t.Errorf("State after a failed reconcile incorrect: %+v", state)
+	}
+
+	tracker.reconciled(key, nil)
+	if state, _ := tracker.get(key); state.Result != "" || state.Error != "" {
+		t.Errorf("Expected the error to be cleared by a successful reconcile, got %+v", state)
+	}
+
+	tracker.forget(key)
+	if _, ok := tracker.get(key); ok {
+		t.Error("Expected the state to be forgotten")
+	}
+}
+
+func TestRecordReconcile(t *testing.T) {
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	defer identityStates.forget(key)
+
+	recordReconcile(identity, nil)
+	if _, ok := identityStates.get(key); !ok {
+		t.Fatal("Expected the reconcile to be recorded")
+	}
+
+	identity.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	recordReconcile(identity, errors.New("cleanup failed"))
+	if state, ok := identityStates.get(key); !ok || state.Error != "cleanup failed" {
+		t.Errorf("Expected a failed cleanup to be recorded, got %+v", state)
+	}
+	recordReconcile(identity, nil)
+	if state, ok := identityStates.get(key); ok {
+		t.Errorf("Expected the released identity to be forgotten, got %+v", state)
+	}
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	handler := NewIntrospectionHandler(inventoryReconciler(t))
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	identityStates.setResult(key, syncResultRoleAssignmentUpdateFailed, syncSummary{})
+	identityStates.reconciled(key, nil)
+	defer identityStates.forget(key)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/identities/testapp")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var statuses []IdentityStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "testapp" || len(statuses[0].ServiceAccounts) != 2 {
+		t.Fatalf("Statuses incorrect: %s", rec.Body.String())
+	}
+	status := statuses[0]
+	if status.LastReconcile == nil || status.LastReconcile.Result != syncResultRoleAssignmentUpdateFailed {
+		t.Errorf("Expected the last reconcile result, got %+v", status.LastReconcile)
+	}
+	wantDrift := []string{
+		"ServiceAccount team-a/workload-identity-testapp has workloads pending a restart",
+		`ServiceAccount team-b/runner has client ID "old-client-id"`,
+		`RoleAssignment default/ra-testapp has principal ID "old-principal-id"`,
+	}
+	for _, want := range wantDrift {
+		found := false
+		for _, drift := range status.Drift {
+			found = found || drift == want
+		}
+		if !found {
+			t.Errorf("Expected drift %q, got %v", want, status.Drift)
+		}
+	}
+
+	if rec := get("/identities"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"appNameError"`) {
+		t.Errorf("Expected all identities, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/identities/otherapp"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown app, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/identities/testapp", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
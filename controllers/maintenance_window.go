@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a recurring window, opening at the minutes matched by a standard
+// five-field cron schedule (minute hour day-of-month month day-of-week, in UTC) and
+// staying open for Duration.
+type MaintenanceWindow struct {
+	Schedule string
+	Duration time.Duration
+
+	minutes, hours, days, months, weekdays uint64
+	// Cron matches either day field when both are restricted
+	anyDay, anyWeekday bool
+}
+
+// maxWindowSearch bounds the search for the next window opening.
+const maxWindowSearch = 366 * 24 * time.Hour
+
+// ParseMaintenanceWindow parses a cron schedule such as `0 2 * * 6` (Saturdays at 02:00 UTC).
+func ParseMaintenanceWindow(schedule string, duration time.Duration) (*MaintenanceWindow, error) {
+	if duration <= 0 || duration > 7*24*time.Hour {
+		return nil, fmt.Errorf("maintenance window duration must be between 0 and 168h, got %s", duration)
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("maintenance window schedule %q must have 5 fields", schedule)
+	}
+
+	w := &MaintenanceWindow{Schedule: schedule, Duration: duration}
+	var err error
+	if w.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if w.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if w.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day-of-month field: %w", err)
+	}
+	if w.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	if w.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day-of-week field: %w", err)
+	}
+	// Sunday is both 0 and 7
+	if w.weekdays&(1<<7) != 0 {
+		w.weekdays |= 1
+	}
+	w.anyDay = fields[2] == "*"
+	w.anyWeekday = fields[4] == "*"
+	return w, nil
+}
+
+// parseCronField parses a comma-separated list of `*`, `n` or `n-m`, each with an optional `/step`.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether the window opens at the minute t.
+func (w *MaintenanceWindow) matches(t time.Time) bool {
+	if w.minutes&(1<<t.Minute()) == 0 || w.hours&(1<<t.Hour()) == 0 || w.months&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	day := w.days&(1<<t.Day()) != 0
+	weekday := w.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case w.anyDay:
+		return weekday
+	case w.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// Open reports whether the window is open at t.
+func (w *MaintenanceWindow) Open(t time.Time) bool {
+	t = t.UTC().Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the window next opens after t, or false if it does not open within a year.
+func (w *MaintenanceWindow) Next(t time.Time) (time.Time, bool) {
+	t = t.UTC().Truncate(time.Minute)
+	for start := t.Add(time.Minute); start.Sub(t) <= maxWindowSearch; start = start.Add(time.Minute) {
+		if w.matches(start) {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		duration time.Duration
+		wantErr  bool
+	}{
+		{name: "nightly", schedule: "0 2 * * *", duration: time.Hour},
+		{name: "lists ranges and steps", schedule: "*/15 1-3,22 1,15 */2 1-5", duration: time.Hour},
+		{name: "sunday as 7", schedule: "0 0 * * 7", duration: time.Hour},
+		{name: "too few fields", schedule: "0 2 * *", duration: time.Hour, wantErr: true},
+		{name: "out of range", schedule: "60 2 * * *", duration: time.Hour, wantErr: true},
+		{name: "reversed range", schedule: "0 5-2 * * *", duration: time.Hour, wantErr: true},
+		{name: "invalid step", schedule: "*/0 2 * * *", duration: time.Hour, wantErr: true},
+		{name: "not a number", schedule: "0 two * * *", duration: time.Hour, wantErr: true},
+		{name: "no duration", schedule: "0 2 * * *", wantErr: true},
+		{name: "duration over a week", schedule: "0 2 * * *", duration: 8 * 24 * time.Hour, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMaintenanceWindow(tt.schedule, tt.duration)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMaintenanceWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindow_Open(t *testing.T) {
+	// Saturdays 02:00-04:00 UTC
+	window, err := ParseMaintenanceWindow("0 2 * * 6", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("ParseMaintenanceWindow() error = %v", err)
+	}
+	saturday := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{name: "before", at: saturday.Add(time.Hour + 59*time.Minute), want: false},
+		{name: "opening", at: saturday.Add(2 * time.Hour), want: true},
+		{name: "inside", at: saturday.Add(3*time.Hour + 30*time.Minute), want: true},
+		{name: "closing", at: saturday.Add(4 * time.Hour), want: false},
+		{name: "other day", at: saturday.Add(24*time.Hour + 3*time.Hour), want: false},
+		{name: "other timezone", at: saturday.Add(3 * time.Hour).In(time.FixedZone("UTC+2", 2*60*60)), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := window.Open(tt.at); got != tt.want {
+				t.Errorf("Open(%s) = %t, want %t", tt.at, got, tt.want)
+			}
+		})
+	}
+
+	next, ok := window.Next(saturday.Add(5 * time.Hour))
+	if !ok || !next.Equal(saturday.Add(7*24*time.Hour+2*time.Hour)) {
+		t.Errorf("Next() = %s, %t, want the following Saturday at 02:00", next, ok)
+	}
+}
+
+func TestMaintenanceWindow_DayFields(t *testing.T) {
+	// The 13th of the month or any Friday, like cron
+	window, err := ParseMaintenanceWindow("0 0 13 * 5", time.Minute)
+	if err != nil {
+		t.Fatalf("ParseMaintenanceWindow() error = %v", err)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{at: time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC), want: true}, // Thursday
+		{at: time.Date(2024, time.June, 14, 0, 0, 0, 0, time.UTC), want: true}, // Friday
+		{at: time.Date(2024, time.June, 15, 0, 0, 0, 0, time.UTC), want: false},
+	} {
+		if got := window.Open(tt.at); got != tt.want {
+			t.Errorf("Open(%s) = %t, want %t", tt.at, got, tt.want)
+		}
+	}
+}
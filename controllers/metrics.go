@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	skipReasonMissingIDs  = "missing_ids"
+	skipReasonInvalidName = "invalid_name"
+)
+
+var (
+	serviceAccountsAnnotatedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clientid_operator_service_accounts_annotated_total",
+		Help: "Number of ServiceAccounts annotated with a new azure.workload.identity/client-id.",
+	})
+	serviceAccountsInjectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clientid_operator_service_accounts_injected_total",
+		Help: "Number of ServiceAccounts given their workload identity annotations by the mutating webhook.",
+	})
+	roleAssignmentsUpdatedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clientid_operator_role_assignments_updated_total",
+		Help: "Number of RoleAssignments re-pointed to a new principal ID.",
+	})
+	roleAssignmentUpdateFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clientid_operator_role_assignment_update_failures_total",
+		Help: "Number of failed attempts to re-point a RoleAssignment to a new principal ID.",
+	})
+	workloadRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clientid_operator_workload_restarts_total",
+		Help: "Number of workload restarts triggered by a client ID change, by kind.",
+	}, []string{"kind"})
+	identitiesSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clientid_operator_identities_skipped_total",
+		Help: "Number of identity reconciles skipped, by reason.",
+	}, []string{"reason"})
+	appNameConflictsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clientid_operator_app_name_conflicts_total",
+		Help: "Number of identity reconciles that found another identity with the same app name.",
+	})
+	dryRunWritesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clientid_operator_dry_run_writes_total",
+		Help: "Number of writes skipped in dry-run mode, by verb and kind.",
+	}, []string{"verb", "kind"})
+	namingViolationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clientid_operator_naming_violations_total",
+		Help: "Number of objects the naming webhook warned about or rejected, by kind.",
+	}, []string{"kind"})
+	identitiesOutOfSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "clientid_operator_identities_out_of_sync",
+		Help: "Number of identities whose last reconcile left dependents out of sync.",
+	})
+	rolloutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clientid_operator_rollouts_total",
+		Help: "Number of rollouts after a client ID change that succeeded or failed, by kind and result.",
+	}, []string{"kind", "result"})
+	stalePods = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "clientid_operator_stale_pods",
+		Help: "Number of running pods whose AZURE_CLIENT_ID differs from their ServiceAccount's client ID.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		serviceAccountsAnnotatedTotal,
+		serviceAccountsInjectedTotal,
+		roleAssignmentsUpdatedTotal,
+		roleAssignmentUpdateFailuresTotal,
+		workloadRestartsTotal,
+		identitiesSkippedTotal,
+		appNameConflictsTotal,
+		dryRunWritesTotal,
+		namingViolationsTotal,
+		identitiesOutOfSync,
+		rolloutsTotal,
+		stalePods,
+	)
+	// Expose the skip reasons before the first skip happens
+	identitiesSkippedTotal.WithLabelValues(skipReasonMissingIDs)
+	identitiesSkippedTotal.WithLabelValues(skipReasonInvalidName)
+}
+
+// syncTracker remembers which identities were left out of sync by their last reconcile
+// and publishes the count as a gauge.
+type syncTracker struct {
+	mu        sync.Mutex
+	outOfSync map[types.NamespacedName]bool
+	gauge     prometheus.Gauge
+}
+
+var outOfSyncIdentities = &syncTracker{outOfSync: map[types.NamespacedName]bool{}, gauge: identitiesOutOfSync}
+
+// set records whether the identity behind key is out of sync.
+func (t *syncTracker) set(key types.NamespacedName, outOfSync bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if outOfSync {
+		t.outOfSync[key] = true
+	} else {
+		delete(t.outOfSync, key)
+	}
+	t.gauge.Set(float64(len(t.outOfSync)))
+}
+
+// forget drops an identity that no longer exists.
+func (t *syncTracker) forget(key types.NamespacedName) {
+	t.set(key, false)
+}
+
+// podCounter keeps a pod count per ServiceAccount and publishes the total as a gauge.
+type podCounter struct {
+	mu     sync.Mutex
+	counts map[types.NamespacedName]int
+	gauge  prometheus.Gauge
+}
+
+var stalePodCounts = &podCounter{counts: map[types.NamespacedName]int{}, gauge: stalePods}
+
+// set records the number of pods of the ServiceAccount behind key.
+func (c *podCounter) set(key types.NamespacedName, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if count > 0 {
+		c.counts[key] = count
+	} else {
+		delete(c.counts, key)
+	}
+	total := 0
+	for _, n := range c.counts {
+		total += n
+	}
+	c.gauge.Set(float64(total))
+}
+
+// forget drops a ServiceAccount that no longer exists.
+func (c *podCounter) forget(key types.NamespacedName) {
+	c.set(key, 0)
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+func TestSyncTracker(t *testing.T) {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_out_of_sync"})
+	tracker := &syncTracker{outOfSync: map[types.NamespacedName]bool{}, gauge: gauge}
+
+	a := types.NamespacedName{Namespace: "default", Name: "a"}
+	b := types.NamespacedName{Name: "b"}
+
+	tracker.set(a, true)
+	tracker.set(b, true)
+	tracker.set(a, true)
+	if got := testutil.ToFloat64(gauge); got != 2 {
+		t.Errorf("Expected 2 identities out of sync, got %v", got)
+	}
+
+	tracker.set(a, false)
+	tracker.forget(b)
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("Expected 0 identities out of sync, got %v", got)
+	}
+}
+
+func TestUserAssignedIdentityReconciler_SkipMetrics(t *testing.T) {
+	s := scheme.Scheme
+	_ = mi.AddToScheme(s)
+
+	badName := "invalid"
+	identity := &mi.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "default"},
+		Spec: mi.UserAssignedIdentitySpec{
+			ForProvider: mi.UserAssignedIdentityParameters{Name: &badName},
+		},
+		Status: mi.UserAssignedIdentityStatus{
+			AtProvider: mi.UserAssignedIdentityObservation{
+				ClientID:    &badName,
+				PrincipalID: &badName,
+			},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(identity).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+
+	skipped := identitiesSkippedTotal.WithLabelValues(skipReasonInvalidName)
+	before := testutil.ToFloat64(skipped)
+
+	key := types.NamespacedName{Name: "invalid", Namespace: "default"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(skipped) - before; got != 1 {
+		t.Errorf("Expected invalid_name skip counter to increase by 1, got %v", got)
+	}
+	if !outOfSyncIdentities.outOfSync[key] {
+		t.Error("Expected identity with an invalid name to be tracked as out of sync")
+	}
+	outOfSyncIdentities.forget(key)
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceScope restricts the namespaces the operator looks for ServiceAccounts in.
+// The zero value includes every namespace.
+type NamespaceScope struct {
+	// Watch lists the only namespaces included.
+	Watch []string
+	// Exclude lists namespaces that are never included.
+	Exclude []string
+	// Selector restricts the included namespaces to those with matching labels. It is the
+	// only setting that makes the operator read Namespaces.
+	Selector labels.Selector
+}
+
+// includesName reports whether namespace passes the Watch and Exclude lists.
+func (s NamespaceScope) includesName(namespace string) bool {
+	if slices.Contains(s.Exclude, namespace) {
+		return false
+	}
+	return len(s.Watch) == 0 || slices.Contains(s.Watch, namespace)
+}
+
+func (s NamespaceScope) selects(namespace *corev1.Namespace) bool {
+	return s.includesName(namespace.Name) && (s.Selector == nil || s.Selector.Matches(labels.Set(namespace.Labels)))
+}
+
+// ByObject restricts the manager cache, for use as cache.Options.ByObject: ServiceAccounts,
+// Pods, the workloads of kinds and the ReplicaSets and Jobs between them to the Watch
+// and Exclude lists, and Namespaces to the Selector. The Crossplane identities, RoleAssignments
+// and FederatedIdentityCredentials live outside the workload namespaces and stay cached in all
+// namespaces. A nil kinds means DefaultWorkloadKinds. It returns nil when nothing is
+// restricted.
+func (s NamespaceScope) ByObject(kinds []string) map[client.Object]cache.ByObject {
+	byObject := map[client.Object]cache.ByObject{}
+	if namespaces := s.cacheNamespaces(); namespaces != nil {
+		objects := []client.Object{&corev1.ServiceAccount{}, &corev1.Pod{}, &appsv1.ReplicaSet{}, &batchv1.Job{}}
+		if kinds == nil {
+			kinds = DefaultWorkloadKinds
+		}
+		for _, name := range kinds {
+			if kind, ok := workloadKinds[name]; ok {
+				objects = append(objects, kind.object())
+			}
+		}
+		for _, obj := range objects {
+			byObject[obj] = cache.ByObject{Namespaces: namespaces}
+		}
+	}
+	if s.Selector != nil {
+		byObject[&corev1.Namespace{}] = cache.ByObject{Label: s.Selector}
+	}
+	if len(byObject) == 0 {
+		return nil
+	}
+	return byObject
+}
+
+// cacheNamespaces returns the namespaces cached for the Watch and Exclude lists, nil when
+// every namespace is included.
+func (s NamespaceScope) cacheNamespaces() map[string]cache.Config {
+	if len(s.Watch) > 0 {
+		namespaces := map[string]cache.Config{}
+		for _, namespace := range s.Watch {
+			if s.includesName(namespace) {
+				namespaces[namespace] = cache.Config{}
+			}
+		}
+		return namespaces
+	}
+	if len(s.Exclude) > 0 {
+		selectors := make([]fields.Selector, 0, len(s.Exclude))
+		for _, namespace := range s.Exclude {
+			selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+		}
+		return map[string]cache.Config{cache.AllNamespaces: {FieldSelector: fields.AndSelectors(selectors...)}}
+	}
+	return nil
+}
+
+// inScope reports whether the ServiceAccount behind key lives in a namespace in scope.
+func (r *UserAssignedIdentityReconciler) inScope(ctx context.Context, key types.NamespacedName) (bool, error) {
+	scope := r.Namespaces
+	if !scope.includesName(key.Namespace) {
+		return false, nil
+	}
+	if scope.Selector == nil {
+		return true, nil
+	}
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: key.Namespace}, &namespace); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return scope.selects(&namespace), nil
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestNamespaceScope_ConventionServiceAccounts(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+
+	enabled := map[string]string{"clientid-operator/enabled": "true"}
+	var objs []client.Object
+	for name, labels := range map[string]map[string]string{"team-a": enabled, "team-b": nil, "kube-system": enabled} {
+		objs = append(objs,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}},
+			&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: name}})
+	}
+
+	tests := []struct {
+		name  string
+		scope NamespaceScope
+		want  []string
+	}{
+		{name: "all namespaces", want: []string{"kube-system", "team-a", "team-b"}},
+		{name: "exclude", scope: NamespaceScope{Exclude: []string{"kube-system"}}, want: []string{"team-a", "team-b"}},
+		{name: "selector", scope: NamespaceScope{Selector: labels.SelectorFromSet(enabled)}, want: []string{"kube-system", "team-a"}},
+		{
+			name:  "selector and exclude",
+			scope: NamespaceScope{Selector: labels.SelectorFromSet(enabled), Exclude: []string{"kube-system"}},
+			want:  []string{"team-a"},
+		},
+		{name: "watch", scope: NamespaceScope{Watch: []string{"team-b", "missing"}}, want: []string{"team-b"}},
+		{
+			name:  "watch and selector",
+			scope: NamespaceScope{Watch: []string{"team-a", "team-b"}, Selector: labels.SelectorFromSet(enabled)},
+			want:  []string{"team-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+			r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), Namespaces: tt.scope}
+
+			keys, err := r.conventionServiceAccounts(context.Background(), "testapp")
+			if err != nil {
+				t.Fatalf("conventionServiceAccounts() error = %v", err)
+			}
+			var got []string
+			for _, key := range keys {
+				got = append(got, key.Namespace)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("conventionServiceAccounts() namespaces = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceScope_WatchWithoutNamespaceAccess(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: "team-a"}}
+	forbidden := func(kind string) error { return fmt.Errorf("%s are forbidden", kind) }
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(sa).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if _, ok := list.(*corev1.NamespaceList); ok {
+					return forbidden("namespaces")
+				}
+				return c.List(ctx, list, opts...)
+			},
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if _, ok := obj.(*corev1.Namespace); ok {
+					return forbidden("namespaces")
+				}
+				if key.Namespace != "team-a" {
+					return forbidden("namespaces outside the cache")
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+	r := &UserAssignedIdentityReconciler{
+		Client:        cl,
+		Scheme:        s,
+		Log:           zap.New(zap.UseDevMode(true)),
+		Namespaces:    NamespaceScope{Watch: []string{"team-a"}},
+		WorkloadKinds: []string{},
+	}
+
+	ctx := context.Background()
+	keys, err := r.conventionServiceAccounts(ctx, "testapp")
+	if err != nil {
+		t.Fatalf("conventionServiceAccounts() error = %v", err)
+	}
+	// A binding may still reference a ServiceAccount outside the watched namespaces
+	keys = append(keys, types.NamespacedName{Name: "workload-identity-testapp", Namespace: "team-b"})
+	result, err := r.updateServiceAccounts(ctx, keys, workloadIdentity{ClientID: "test-client-id"}, r.Log)
+	if err != nil {
+		t.Fatalf("updateServiceAccounts() error = %v", err)
+	}
+	if len(result.PatchedServiceAccounts) != 1 || result.PatchedServiceAccounts[0].Namespace != "team-a" {
+		t.Errorf("PatchedServiceAccounts incorrect. Expected team-a only, got %v", result.PatchedServiceAccounts)
+	}
+}
+
+func TestNamespaceScope_ByObject(t *testing.T) {
+	if got := (NamespaceScope{}).ByObject(nil); got != nil {
+		t.Errorf("ByObject() = %v, want nil", got)
+	}
+
+	namespacesOf := func(byObject map[client.Object]cache.ByObject, obj client.Object) map[string]cache.Config {
+		for o, config := range byObject {
+			if reflect.TypeOf(o) == reflect.TypeOf(obj) {
+				return config.Namespaces
+			}
+		}
+		return nil
+	}
+
+	watched := NamespaceScope{Watch: []string{"team-a", "team-b"}, Exclude: []string{"team-b"}}.ByObject([]string{WorkloadKindDeployment})
+	for _, obj := range []client.Object{&corev1.ServiceAccount{}, &corev1.Pod{}, &appsv1.Deployment{}} {
+		namespaces := namespacesOf(watched, obj)
+		if _, ok := namespaces["team-a"]; !ok || len(namespaces) != 1 {
+			t.Errorf("ByObject()[%T] = %v, want team-a only", obj, namespaces)
+		}
+	}
+	// The Crossplane kinds live outside the workload namespaces
+	for _, obj := range []client.Object{&mi.UserAssignedIdentity{}, &ra.RoleAssignment{}, &appsv1.StatefulSet{}} {
+		if namespaces := namespacesOf(watched, obj); namespaces != nil {
+			t.Errorf("ByObject()[%T] = %v, want all namespaces", obj, namespaces)
+		}
+	}
+
+	excluded := NamespaceScope{Exclude: []string{"kube-system"}}.ByObject(nil)
+	all, ok := namespacesOf(excluded, &corev1.ServiceAccount{})[cache.AllNamespaces]
+	if !ok || all.FieldSelector == nil {
+		t.Fatalf("ByObject() = %v, want all namespaces with a field selector", excluded)
+	}
+	if got, want := all.FieldSelector.String(), "metadata.namespace!=kube-system"; got != want {
+		t.Errorf("FieldSelector = %q, want %q", got, want)
+	}
+	if namespacesOf(excluded, &appsv1.StatefulSet{}) == nil {
+		t.Error("ByObject() should restrict the default workload kinds")
+	}
+
+	selected := NamespaceScope{Selector: labels.SelectorFromSet(labels.Set{"team": "a"})}.ByObject(nil)
+	if len(selected) != 1 || namespacesOf(selected, &corev1.ServiceAccount{}) != nil {
+		t.Errorf("ByObject() with only a selector = %v, want Namespaces only", selected)
+	}
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+const (
+	// NamingValidationOff disables the naming webhook.
+	NamingValidationOff = "off"
+	// NamingValidationWarn admits objects breaking the naming convention with a warning.
+	NamingValidationWarn = "warn"
+	// NamingValidationDeny rejects objects breaking the naming convention.
+	NamingValidationDeny = "deny"
+
+	// NamingWebhookPath is where the naming webhook is served.
+	NamingWebhookPath = "/validate-naming"
+)
+
+// ValidateNamingValidation checks that mode is a known naming validation mode.
+func ValidateNamingValidation(mode string) error {
+	switch mode {
+	case NamingValidationOff, NamingValidationWarn, NamingValidationDeny:
+		return nil
+	default:
+		return fmt.Errorf("unknown naming validation mode %q, must be one of %s, %s or %s",
+			mode, NamingValidationOff, NamingValidationWarn, NamingValidationDeny)
+	}
+}
+
+// NamingValidator is a validating admission webhook that checks UserAssignedIdentities,
+// ServiceAccounts and RoleAssignments against the naming convention, so mistakes show up
+// when they are applied rather than as skipped reconciles. Objects covered by an
+// IdentityBinding are exempt, as bindings exist for resources outside the convention.
+type NamingValidator struct {
+	Decoder admission.Decoder
+	// Identities provides the client, the app name extraction, the namespace scope and
+	// whether IdentityBindings are enabled.
+	Identities *UserAssignedIdentityReconciler
+	// Deny rejects violations instead of admitting them with a warning.
+	Deny bool
+}
+
+// SetupWebhookWithManager registers the validator with the manager's webhook server.
+func (v *NamingValidator) SetupWebhookWithManager(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(NamingWebhookPath, &webhook.Admission{Handler: v})
+}
+
+// Handle validates CREATE and UPDATE requests; other operations are always allowed. An
+// UPDATE is only denied when it introduces the violation, so objects that predate the
+// convention can still be changed, for example to remove their finalizers.
+func (v *NamingValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	violation, err := v.validate(ctx, req)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if violation == "" {
+		return admission.Allowed("")
+	}
+	namingViolationsTotal.WithLabelValues(req.Kind.Kind).Inc()
+	if !v.Deny {
+		return admission.Allowed("").WithWarnings(violation)
+	}
+	if req.Operation == admissionv1.Update {
+		old := req
+		old.Object = req.OldObject
+		oldViolation, err := v.validate(ctx, old)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if oldViolation != "" {
+			return admission.Allowed("").WithWarnings(violation)
+		}
+	}
+	return admission.Denied(violation)
+}
+
+// validate returns the naming violation of the object in req, or "" if there is none.
+func (v *NamingValidator) validate(ctx context.Context, req admission.Request) (string, error) {
+	switch {
+	case req.Kind.Kind == "UserAssignedIdentity":
+		return v.validateIdentity(ctx, req)
+	case req.Kind.Kind == "RoleAssignment":
+		return v.validateRoleAssignment(ctx, req)
+	case req.Kind.Group == "" && req.Kind.Kind == "ServiceAccount":
+		return v.validateServiceAccount(ctx, req)
+	}
+	return "", nil
+}
+
+// validateIdentity checks that the app name can be derived from the identity.
+func (v *NamingValidator) validateIdentity(ctx context.Context, req admission.Request) (string, error) {
+	var identity client.Object = &mi.UserAssignedIdentity{}
+	if req.Kind.Group == mi2.CRDGroup {
+		identity = &mi2.UserAssignedIdentity{}
+	}
+	if err := v.Decoder.Decode(req, identity); err != nil {
+		return "", err
+	}
+	if v.Identities.IdentityBindings {
+		bound, err := v.Identities.isBound(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace})
+		if err != nil || bound {
+			return "", err
+		}
+	}
+	if _, err := v.Identities.extractAppName(identity); err != nil {
+		return fmt.Sprintf("UserAssignedIdentity %s does not follow the naming convention: %v", req.Name, err), nil
+	}
+	return "", nil
+}
+
+// validateRoleAssignment checks that the RoleAssignment carries the labels that link it to its app.
+func (v *NamingValidator) validateRoleAssignment(ctx context.Context, req admission.Request) (string, error) {
+	var roleAssignment unstructured.Unstructured
+	if err := v.Decoder.Decode(req, &roleAssignment); err != nil {
+		return "", err
+	}
+	objLabels := roleAssignment.GetLabels()
+	if objLabels["application"] != "" && objLabels["type"] == "roleassignment" {
+		return "", nil
+	}
+	selected, err := v.selectedByBinding(ctx, objLabels)
+	if err != nil || selected {
+		return "", err
+	}
+	return fmt.Sprintf("RoleAssignment %s needs the labels application: {appName} and type: roleassignment", req.Name), nil
+}
+
+// validateServiceAccount checks that an identity exists for the app a ServiceAccount belongs to.
+func (v *NamingValidator) validateServiceAccount(ctx context.Context, req admission.Request) (string, error) {
+	var sa unstructured.Unstructured
+	if err := v.Decoder.Decode(req, &sa); err != nil {
+		return "", err
+	}
+	apps := serviceAccountApp(&sa)
+	if len(apps) == 0 {
+		return "", nil
+	}
+	inScope, err := v.Identities.inScope(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace})
+	if err != nil || !inScope {
+		return "", err
+	}
+	if len(v.Identities.identitiesForApp(ctx, apps[0])) > 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("ServiceAccount %s belongs to app %s, which has no UserAssignedIdentity", req.Name, apps[0]), nil
+}
+
+// selectedByBinding reports whether any IdentityBinding selects RoleAssignments with objLabels.
+func (v *NamingValidator) selectedByBinding(ctx context.Context, objLabels map[string]string) (bool, error) {
+	if !v.Identities.IdentityBindings {
+		return false, nil
+	}
+	var bindings identityv1alpha1.IdentityBindingList
+	if err := v.Identities.List(ctx, &bindings); err != nil {
+		return false, err
+	}
+	for _, binding := range bindings.Items {
+		if binding.Spec.RoleAssignmentSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(binding.Spec.RoleAssignmentSelector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(objLabels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
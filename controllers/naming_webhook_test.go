@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func admissionRequest(t *testing.T, operation admissionv1.Operation, kind metav1.GroupVersionKind, obj client.Object) admission.Request {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("Failed to marshal %s: %v", kind.Kind, err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		Kind:      kind,
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func TestNamingValidator(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = identityv1alpha1.AddToScheme(s)
+
+	binding := &identityv1alpha1.IdentityBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "default"},
+		Spec: identityv1alpha1.IdentityBindingSpec{
+			IdentityRef:            identityv1alpha1.IdentityReference{Name: "legacy", Namespace: "default"},
+			RoleAssignmentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "legacy"}},
+		},
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).
+		WithObjects(identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001"), binding).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), IdentityBindings: true}
+
+	identityKind := metav1.GroupVersionKind{Group: mi.CRDGroup, Version: mi.CRDVersion, Kind: "UserAssignedIdentity"}
+	roleAssignmentKind := metav1.GroupVersionKind{Group: ra.CRDGroup, Version: ra.CRDVersion, Kind: "RoleAssignment"}
+	serviceAccountKind := metav1.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}
+	roleAssignment := func(labels map[string]string) *ra.RoleAssignment {
+		return &ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{Name: "ra", Namespace: "default", Labels: labels}}
+	}
+	serviceAccount := func(name string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		kind      metav1.GroupVersionKind
+		obj       client.Object
+		violation bool
+	}{
+		{name: "conventional identity", kind: identityKind, obj: identityNamed("other", "default", "id-service-other-dv-azunea-001")},
+		{name: "unparsable identity", kind: identityKind, obj: identityNamed("other", "default", "other"), violation: true},
+		{name: "bound identity", kind: identityKind, obj: identityNamed("legacy", "default", "legacy")},
+		{name: "deleted identity", operation: admissionv1.Delete, kind: identityKind, obj: identityNamed("other", "default", "other")},
+		{name: "labelled RoleAssignment", kind: roleAssignmentKind, obj: roleAssignment(map[string]string{"application": "testapp", "type": "roleassignment"})},
+		{name: "unlabelled RoleAssignment", kind: roleAssignmentKind, obj: roleAssignment(map[string]string{"application": "testapp"}), violation: true},
+		{name: "bound RoleAssignment", kind: roleAssignmentKind, obj: roleAssignment(map[string]string{"team": "legacy"})},
+		{name: "ServiceAccount of an app", kind: serviceAccountKind, obj: serviceAccount("workload-identity-testapp")},
+		{name: "ServiceAccount of a missing app", kind: serviceAccountKind, obj: serviceAccount("workload-identity-missing"), violation: true},
+		{name: "unrelated ServiceAccount", kind: serviceAccountKind, obj: serviceAccount("default")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation := tt.operation
+			if operation == "" {
+				operation = admissionv1.Create
+			}
+			req := admissionRequest(t, operation, tt.kind, tt.obj)
+
+			warn := &NamingValidator{Decoder: admission.NewDecoder(s), Identities: r}
+			resp := warn.Handle(context.Background(), req)
+			if !resp.Allowed {
+				t.Errorf("Expected warn mode to allow, got %v", resp.Result)
+			}
+			if got := len(resp.Warnings) > 0; got != tt.violation {
+				t.Errorf("Warnings incorrect. Expected violation %v, got %v", tt.violation, resp.Warnings)
+			}
+
+			deny := &NamingValidator{Decoder: admission.NewDecoder(s), Identities: r, Deny: true}
+			if resp := deny.Handle(context.Background(), req); resp.Allowed == tt.violation {
+				t.Errorf("Expected deny mode to allow %v, got %v", !tt.violation, resp.Allowed)
+			}
+		})
+	}
+}
+
+func TestNamingValidator_Update(t *testing.T) {
+	s := scheme.Scheme
+	_ = mi.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = identityv1alpha1.AddToScheme(s)
+
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+	deny := &NamingValidator{Decoder: admission.NewDecoder(s), Identities: r, Deny: true}
+	roleAssignmentKind := metav1.GroupVersionKind{Group: ra.CRDGroup, Version: ra.CRDVersion, Kind: "RoleAssignment"}
+	roleAssignment := func(labels map[string]string) *ra.RoleAssignment {
+		return &ra.RoleAssignment{ObjectMeta: metav1.ObjectMeta{Name: "ra", Namespace: "default", Labels: labels}}
+	}
+	update := func(old, obj client.Object) admission.Response {
+		req := admissionRequest(t, admissionv1.Update, roleAssignmentKind, obj)
+		req.OldObject = admissionRequest(t, admissionv1.Update, roleAssignmentKind, old).Object
+		return deny.Handle(context.Background(), req)
+	}
+
+	// An object that already broke the convention can still be changed, with a warning
+	existing := roleAssignment(map[string]string{"application": "testapp"})
+	changed := roleAssignment(map[string]string{"application": "testapp", "team": "a"})
+	changed.Finalizers = []string{"finalizer.managedresource.crossplane.io"}
+	if resp := update(existing, changed); !resp.Allowed || len(resp.Warnings) == 0 {
+		t.Errorf("Expected the update of a non-compliant object to be allowed with a warning, got %v %v", resp.Allowed, resp.Warnings)
+	}
+
+	// An update breaking the convention is denied
+	compliant := roleAssignment(map[string]string{"application": "testapp", "type": "roleassignment"})
+	if resp := update(compliant, existing); resp.Allowed {
+		t.Error("Expected an update introducing a violation to be denied")
+	}
+}
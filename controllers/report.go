@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+const (
+	// ReportFormatTable prints one row per dependent of each identity.
+	ReportFormatTable = "table"
+	// ReportFormatJSON prints the inventory as a JSON array.
+	ReportFormatJSON = "json"
+	// ReportFormatMermaid prints a Mermaid flowchart.
+	ReportFormatMermaid = "mermaid"
+	// ReportFormatDOT prints a Graphviz digraph.
+	ReportFormatDOT = "dot"
+)
+
+// IdentityReport describes an identity and the dependents it is wired to, by its
+// IdentityBindings when it has any and by naming convention otherwise.
+type IdentityReport struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// Scope is namespaced or cluster.
+	Scope       string `json:"scope"`
+	AppName     string `json:"appName,omitempty"`
+	AppNameErr  string `json:"appNameError,omitempty"`
+	ClientID    string `json:"clientID,omitempty"`
+	PrincipalID string `json:"principalID,omitempty"`
+	// BoundBy names the IdentityBindings of the identity.
+	BoundBy []string `json:"boundBy,omitempty"`
+
+	ServiceAccounts []ServiceAccountReport `json:"serviceAccounts,omitempty"`
+	RoleAssignments []RoleAssignmentReport `json:"roleAssignments,omitempty"`
+}
+
+// ServiceAccountReport describes a ServiceAccount of an identity and the workloads using it.
+type ServiceAccountReport struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	ClientID  string `json:"clientID,omitempty"`
+	// Current reports whether the client ID annotation matches the identity.
+	Current bool `json:"current"`
+	// PendingRestart reports whether workloads still await a restart for the client ID.
+	PendingRestart bool             `json:"pendingRestart,omitempty"`
+	Workloads      []WorkloadReport `json:"workloads,omitempty"`
+}
+
+// WorkloadReport names a workload running as a ServiceAccount.
+type WorkloadReport struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// RoleAssignmentReport describes a RoleAssignment of an identity.
+type RoleAssignmentReport struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace,omitempty"`
+	PrincipalID string `json:"principalID,omitempty"`
+	// Current reports whether the principal ID matches the identity.
+	Current bool `json:"current"`
+}
+
+func (i IdentityReport) key() string {
+	return types.NamespacedName{Name: i.Name, Namespace: i.Namespace}.String()
+}
+
+// Inventory reports every identity of either scope with the ServiceAccounts, workloads and
+// RoleAssignments it is wired to, sorted by namespace and name. Bound identities are reported
+// with the subjects of their IdentityBindings, when the CRD is installed. It only uses plain
+// list calls, so it works with a client that has no cache or field indexes.
+func (r *UserAssignedIdentityReconciler) Inventory(ctx context.Context) ([]IdentityReport, error) {
+	var identities []client.Object
+	var namespaced mi.UserAssignedIdentityList
+	if err := r.List(ctx, &namespaced); err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	for i := range namespaced.Items {
+		identities = append(identities, &namespaced.Items[i])
+	}
+	var cluster mi2.UserAssignedIdentityList
+	if err := r.List(ctx, &cluster); err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	for i := range cluster.Items {
+		identities = append(identities, &cluster.Items[i])
+	}
+
+	var serviceAccounts corev1.ServiceAccountList
+	if err := r.List(ctx, &serviceAccounts); err != nil {
+		return nil, err
+	}
+	workloads, err := r.workloadsByServiceAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var bindings identityv1alpha1.IdentityBindingList
+	if err := r.List(ctx, &bindings); err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	bindingsByIdentity := map[types.NamespacedName][]identityv1alpha1.IdentityBinding{}
+	for _, binding := range bindings.Items {
+		key := types.NamespacedName{Name: binding.Spec.IdentityRef.Name, Namespace: binding.Spec.IdentityRef.Namespace}
+		bindingsByIdentity[key] = append(bindingsByIdentity[key], binding)
+	}
+
+	reports := make([]IdentityReport, 0, len(identities))
+	for _, identity := range identities {
+		report := r.identityReport(identity)
+		addServiceAccount := func(sa *corev1.ServiceAccount) {
+			clientID := sa.Annotations[clientIDAnnotation]
+			report.ServiceAccounts = append(report.ServiceAccounts, ServiceAccountReport{
+				Name:           sa.Name,
+				Namespace:      sa.Namespace,
+				ClientID:       clientID,
+				Current:        clientID != "" && clientID == report.ClientID,
+				PendingRestart: sa.Annotations[pendingRestartAnnotation] != "",
+				Workloads:      workloads[client.ObjectKeyFromObject(sa)],
+			})
+		}
+		addRoleAssignments := func(selector labels.Selector, scope roleAssignmentScope) {
+			for _, roleAssignment := range r.listRoleAssignments(ctx, selector, scope, r.Log) {
+				principalID := ptr.Deref(rolePrincipalID(roleAssignment), "")
+				report.RoleAssignments = append(report.RoleAssignments, RoleAssignmentReport{
+					Name:        roleAssignment.GetName(),
+					Namespace:   roleAssignment.GetNamespace(),
+					PrincipalID: principalID,
+					Current:     principalID != "" && principalID == report.PrincipalID,
+				})
+			}
+		}
+
+		if bound := bindingsByIdentity[client.ObjectKeyFromObject(identity)]; len(bound) > 0 {
+			var serviceAccountSelectors, roleAssignmentSelectors []labels.Selector
+			listed := map[types.NamespacedName]bool{}
+			for _, binding := range bound {
+				report.BoundBy = append(report.BoundBy, binding.Name)
+				for _, ref := range binding.Spec.ServiceAccounts {
+					listed[types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}] = true
+				}
+				if selector, err := metav1.LabelSelectorAsSelector(binding.Spec.ServiceAccountSelector); err == nil && binding.Spec.ServiceAccountSelector != nil {
+					serviceAccountSelectors = append(serviceAccountSelectors, selector)
+				}
+				if selector, err := metav1.LabelSelectorAsSelector(binding.Spec.RoleAssignmentSelector); err == nil && binding.Spec.RoleAssignmentSelector != nil {
+					roleAssignmentSelectors = append(roleAssignmentSelectors, selector)
+				}
+			}
+			slices.Sort(report.BoundBy)
+			for _, sa := range serviceAccounts.Items {
+				if listed[client.ObjectKeyFromObject(&sa)] || slices.ContainsFunc(serviceAccountSelectors, func(selector labels.Selector) bool {
+					return selector.Matches(labels.Set(sa.Labels))
+				}) {
+					addServiceAccount(&sa)
+				}
+			}
+			for _, selector := range roleAssignmentSelectors {
+				addRoleAssignments(selector, allRoleAssignments)
+			}
+			reports = append(reports, report)
+			continue
+		}
+
+		if report.AppName == "" {
+			reports = append(reports, report)
+			continue
+		}
+		for _, sa := range serviceAccounts.Items {
+			if apps := serviceAccountApp(&sa); len(apps) == 0 || apps[0] != report.AppName {
+				continue
+			}
+			addServiceAccount(&sa)
+		}
+		addRoleAssignments(conventionRoleAssignmentSelector(report.AppName), r.roleAssignmentScopeFor(identity))
+		reports = append(reports, report)
+	}
+
+	slices.SortFunc(reports, func(a, b IdentityReport) int { return strings.Compare(a.key(), b.key()) })
+	return reports, nil
+}
+
+// identityReport describes identity without its dependents.
+func (r *UserAssignedIdentityReconciler) identityReport(identity client.Object) IdentityReport {
+	report := IdentityReport{Name: identity.GetName(), Namespace: identity.GetNamespace()}
+	switch identity := identity.(type) {
+	case *mi.UserAssignedIdentity:
+		report.Scope = "namespaced"
+		report.ClientID = ptr.Deref(identity.Status.AtProvider.ClientID, "")
+		report.PrincipalID = ptr.Deref(identity.Status.AtProvider.PrincipalID, "")
+	case *mi2.UserAssignedIdentity:
+		report.Scope = "cluster"
+		report.ClientID = ptr.Deref(identity.Status.AtProvider.ClientID, "")
+		report.PrincipalID = ptr.Deref(identity.Status.AtProvider.PrincipalID, "")
+	}
+	if appName, err := r.extractAppName(identity); err != nil {
+		report.AppNameErr = err.Error()
+	} else {
+		report.AppName = appName
+	}
+	return report
+}
+
+// workloadsByServiceAccount lists the configured workload kinds in all namespaces and groups
+// them by the ServiceAccount their pods run as. Kinds whose CRD is not installed are skipped.
+func (r *UserAssignedIdentityReconciler) workloadsByServiceAccount(ctx context.Context) (map[types.NamespacedName][]WorkloadReport, error) {
+	workloads := map[types.NamespacedName][]WorkloadReport{}
+	for _, kind := range r.workloads() {
+		list := kind.list()
+		if err := r.List(ctx, list); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", kind.kind, err)
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			workload := item.(client.Object)
+			key := types.NamespacedName{Name: kind.serviceAccountName(workload), Namespace: workload.GetNamespace()}
+			workloads[key] = append(workloads[key], WorkloadReport{Kind: kind.kind, Name: workload.GetName()})
+		}
+	}
+	return workloads, nil
+}
+
+// ValidateReportFormat checks that format is a known report format.
+func ValidateReportFormat(format string) error {
+	switch format {
+	case ReportFormatTable, ReportFormatJSON, ReportFormatMermaid, ReportFormatDOT:
+		return nil
+	default:
+		return fmt.Errorf("unknown report format %q, must be one of %s, %s, %s or %s",
+			format, ReportFormatTable, ReportFormatJSON, ReportFormatMermaid, ReportFormatDOT)
+	}
+}
+
+// WriteReport writes reports to w in format.
+func WriteReport(w io.Writer, reports []IdentityReport, format string) error {
+	switch format {
+	case ReportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if reports == nil {
+			reports = []IdentityReport{}
+		}
+		return enc.Encode(reports)
+	case ReportFormatMermaid:
+		return writeReportGraph(w, reports, mermaidGraph{})
+	case ReportFormatDOT:
+		return writeReportGraph(w, reports, dotGraph{})
+	default:
+		return writeReportTable(w, reports)
+	}
+}
+
+func status(current bool) string {
+	if current {
+		return "current"
+	}
+	return "stale"
+}
+
+// writeReportTable prints one row per dependent, with the identity columns on its first row.
+func writeReportTable(w io.Writer, reports []IdentityReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IDENTITY\tAPP\tBOUND BY\tCLIENT ID\tPRINCIPAL ID\tDEPENDENT\tSTATUS\tWORKLOADS")
+	for _, report := range reports {
+		app := report.AppName
+		if app == "" {
+			app = "<invalid>"
+		}
+		identity := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", report.key(), app, dash(strings.Join(report.BoundBy, ",")), dash(report.ClientID), dash(report.PrincipalID))
+		var rows []string
+		for _, sa := range report.ServiceAccounts {
+			var workloads []string
+			for _, workload := range sa.Workloads {
+				workloads = append(workloads, workload.Kind+" "+workload.Name)
+			}
+			rows = append(rows, fmt.Sprintf("ServiceAccount %s/%s\t%s\t%s", sa.Namespace, sa.Name, status(sa.Current), dash(strings.Join(workloads, ", "))))
+		}
+		for _, roleAssignment := range report.RoleAssignments {
+			name := types.NamespacedName{Name: roleAssignment.Name, Namespace: roleAssignment.Namespace}.String()
+			rows = append(rows, fmt.Sprintf("RoleAssignment %s\t%s\t-", name, status(roleAssignment.Current)))
+		}
+		if len(rows) == 0 {
+			rows = append(rows, "-\t-\t-")
+		}
+		for i, row := range rows {
+			if i > 0 {
+				identity = "\t\t\t\t"
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", identity, row)
+		}
+	}
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// graphSyntax renders the nodes and edges of a report graph.
+type graphSyntax interface {
+	header() string
+	node(id, label string) string
+	edge(from, to string, stale bool) string
+	footer() string
+}
+
+// writeReportGraph draws identities, their ServiceAccounts and RoleAssignments, and the
+// workloads using the ServiceAccounts. Edges to stale dependents are marked.
+func writeReportGraph(w io.Writer, reports []IdentityReport, g graphSyntax) error {
+	var b strings.Builder
+	b.WriteString(g.header())
+	for i, report := range reports {
+		identityID := fmt.Sprintf("id%d", i)
+		label := "UserAssignedIdentity " + report.key()
+		if report.AppName != "" {
+			label += "\\napp: " + report.AppName
+		}
+		if len(report.BoundBy) > 0 {
+			label += "\\nbound by: " + strings.Join(report.BoundBy, ", ")
+		}
+		b.WriteString(g.node(identityID, label))
+		for j, sa := range report.ServiceAccounts {
+			saID := fmt.Sprintf("id%d_sa%d", i, j)
+			b.WriteString(g.node(saID, fmt.Sprintf("ServiceAccount %s/%s", sa.Namespace, sa.Name)))
+			b.WriteString(g.edge(identityID, saID, !sa.Current))
+			for k, workload := range sa.Workloads {
+				workloadID := fmt.Sprintf("%s_w%d", saID, k)
+				b.WriteString(g.node(workloadID, fmt.Sprintf("%s %s/%s", workload.Kind, sa.Namespace, workload.Name)))
+				b.WriteString(g.edge(saID, workloadID, false))
+			}
+		}
+		for j, roleAssignment := range report.RoleAssignments {
+			raID := fmt.Sprintf("id%d_ra%d", i, j)
+			name := types.NamespacedName{Name: roleAssignment.Name, Namespace: roleAssignment.Namespace}.String()
+			b.WriteString(g.node(raID, "RoleAssignment "+name))
+			b.WriteString(g.edge(identityID, raID, !roleAssignment.Current))
+		}
+	}
+	b.WriteString(g.footer())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type mermaidGraph struct{}
+
+func (mermaidGraph) header() string { return "flowchart LR\n" }
+func (mermaidGraph) node(id, label string) string {
+	return fmt.Sprintf("  %s[\"%s\"]\n", id, strings.ReplaceAll(label, "\\n", "<br/>"))
+}
+func (mermaidGraph) edge(from, to string, stale bool) string {
+	if stale {
+		return fmt.Sprintf("  %s -. stale .-> %s\n", from, to)
+	}
+	return fmt.Sprintf("  %s --> %s\n", from, to)
+}
+func (mermaidGraph) footer() string { return "" }
+
+type dotGraph struct{}
+
+func (dotGraph) header() string { return "digraph identities {\n  rankdir=LR;\n  node [shape=box];\n" }
+func (dotGraph) node(id, label string) string {
+	return fmt.Sprintf("  %s [label=\"%s\"];\n", id, label)
+}
+func (dotGraph) edge(from, to string, stale bool) string {
+	if stale {
+		return fmt.Sprintf("  %s -> %s [style=dashed, color=red, label=\"stale\"];\n", from, to)
+	}
+	return fmt.Sprintf("  %s -> %s;\n", from, to)
+}
+func (dotGraph) footer() string { return "}\n" }
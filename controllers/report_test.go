@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// inventoryReconciler has a named identity of testapp with a current and a stale
+// ServiceAccount and a stale RoleAssignment, an identity with an unparsable name, and an
+// identity bound by an IdentityBinding.
+func inventoryReconciler(t *testing.T) *UserAssignedIdentityReconciler {
+	t.Helper()
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = ra2.AddToScheme(s)
+	_ = identityv1alpha1.AddToScheme(s)
+
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	invalid := identityNamed("invalid", "default", "invalid")
+	bound := identityNamed("payments", "team-c", "payments-api-identity")
+	bound.Status.AtProvider.ClientID = ptr.To("payments-client-id")
+	bound.Status.AtProvider.PrincipalID = ptr.To("payments-principal-id")
+	objs := []client.Object{
+		identity,
+		invalid,
+		bound,
+		&identityv1alpha1.IdentityBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+			Spec: identityv1alpha1.IdentityBindingSpec{
+				IdentityRef:            identityv1alpha1.IdentityReference{Name: "payments", Namespace: "team-c"},
+				ServiceAccounts:        []identityv1alpha1.ServiceAccountReference{{Name: "payments-api", Namespace: "team-c"}},
+				RoleAssignmentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			},
+		},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "payments-api", Namespace: "team-c", Annotations: map[string]string{clientIDAnnotation: "payments-client-id"},
+		}},
+		&ra2.RoleAssignment{
+			ObjectMeta: metav1.ObjectMeta{Name: "ra-payments", Labels: map[string]string{"team": "payments"}},
+			Spec:       ra2.RoleAssignmentSpec{ForProvider: ra2.RoleAssignmentParameters{PrincipalID: ptr.To("payments-principal-id")}},
+		},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "workload-identity-testapp", Namespace: "team-a",
+			Annotations: map[string]string{clientIDAnnotation: "test-client-id", pendingRestartAnnotation: "test-client-id"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "runner", Namespace: "team-b", Labels: map[string]string{ServiceAccountAppLabel: "testapp"},
+			Annotations: map[string]string{clientIDAnnotation: "old-client-id"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "team-a"}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "team-a"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{ServiceAccountName: "workload-identity-testapp"},
+			}},
+		},
+		&ra.RoleAssignment{
+			ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp", Namespace: "default", Labels: map[string]string{"application": "testapp", "type": "roleassignment"}},
+			Spec:       ra.RoleAssignmentSpec{ForProvider: ra.RoleAssignmentParameters{PrincipalID: ptr.To("old-principal-id")}},
+		},
+	}
+	return &UserAssignedIdentityReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build(),
+		Scheme:        s,
+		Log:           zap.New(zap.UseDevMode(true)),
+		WorkloadKinds: []string{WorkloadKindDeployment},
+	}
+}
+
+func inventoryFixture(t *testing.T) []IdentityReport {
+	t.Helper()
+	reports, err := inventoryReconciler(t).Inventory(context.Background())
+	if err != nil {
+		t.Fatalf("Inventory() error = %v", err)
+	}
+	return reports
+}
+
+func TestInventory(t *testing.T) {
+	reports := inventoryFixture(t)
+	if len(reports) != 3 {
+		t.Fatalf("Expected 3 identities, got %d", len(reports))
+	}
+	if reports[0].Name != "invalid" || reports[0].AppNameErr == "" {
+		t.Errorf("Expected the unparsable identity first with an app name error, got %+v", reports[0])
+	}
+
+	report := reports[1]
+	if report.AppName != "testapp" || report.Scope != "namespaced" || report.ClientID != "test-client-id" {
+		t.Errorf("Identity report incorrect: %+v", report)
+	}
+	if len(report.ServiceAccounts) != 2 {
+		t.Fatalf("Expected 2 ServiceAccounts, got %+v", report.ServiceAccounts)
+	}
+	for _, sa := range report.ServiceAccounts {
+		switch sa.Name {
+		case "workload-identity-testapp":
+			if !sa.Current || len(sa.Workloads) != 1 || sa.Workloads[0] != (WorkloadReport{Kind: WorkloadKindDeployment, Name: "api"}) {
+				t.Errorf("ServiceAccount report incorrect: %+v", sa)
+			}
+		case "runner":
+			if sa.Current || len(sa.Workloads) != 0 {
+				t.Errorf("ServiceAccount report incorrect: %+v", sa)
+			}
+		default:
+			t.Errorf("Unexpected ServiceAccount %s", sa.Name)
+		}
+	}
+	if len(report.RoleAssignments) != 1 || report.RoleAssignments[0].Current || report.RoleAssignments[0].PrincipalID != "old-principal-id" {
+		t.Errorf("RoleAssignment report incorrect: %+v", report.RoleAssignments)
+	}
+
+	// The bound identity is reported with the subjects of its binding, not by naming convention
+	report = reports[2]
+	if report.Name != "payments" || !slices.Equal(report.BoundBy, []string{"payments"}) {
+		t.Errorf("Bound identity report incorrect: %+v", report)
+	}
+	if len(report.ServiceAccounts) != 1 || report.ServiceAccounts[0].Name != "payments-api" || !report.ServiceAccounts[0].Current {
+		t.Errorf("Bound ServiceAccount report incorrect: %+v", report.ServiceAccounts)
+	}
+	if len(report.RoleAssignments) != 1 || report.RoleAssignments[0].Name != "ra-payments" || !report.RoleAssignments[0].Current {
+		t.Errorf("Bound RoleAssignment report incorrect: %+v", report.RoleAssignments)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	reports := inventoryFixture(t)
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: ReportFormatTable, want: []string{
+			"IDENTITY", "default/testapp", "ServiceAccount team-a/workload-identity-testapp", "current", "Deployment api",
+			"ServiceAccount team-b/runner", "stale", "RoleAssignment default/ra-testapp", "<invalid>",
+			"team-c/payments", "payments", "ServiceAccount team-c/payments-api",
+		}},
+		{format: ReportFormatMermaid, want: []string{
+			"flowchart LR", `id1["UserAssignedIdentity default/testapp<br/>app: testapp"]`, "id1 --> id1_sa0", "id1 -. stale .-> id1_sa1",
+			"id1_sa0 --> id1_sa0_w0", "id1 -. stale .-> id1_ra0", `<br/>bound by: payments"]`,
+		}},
+		{format: ReportFormatDOT, want: []string{
+			"digraph identities {", `id1 [label="UserAssignedIdentity default/testapp\napp: testapp"];`, "id1 -> id1_sa0;",
+			"id1 -> id1_sa1 [style=dashed", "}",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteReport(&out, reports, tt.format); err != nil {
+				t.Fatalf("WriteReport() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+
+	t.Run(ReportFormatJSON, func(t *testing.T) {
+		var out bytes.Buffer
+		if err := WriteReport(&out, reports, ReportFormatJSON); err != nil {
+			t.Fatalf("WriteReport() error = %v", err)
+		}
+		var got []IdentityReport
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		if len(got) != 3 || got[1].ServiceAccounts[0].Workloads[0].Name != "api" || got[2].BoundBy[0] != "payments" {
+			t.Errorf("JSON report incorrect: %s", out.String())
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	RestartPolicyNever             = "never"
+	RestartPolicyImmediate         = "immediate"
+	RestartPolicyStaggered         = "staggered"
+	RestartPolicyMaintenanceWindow = "maintenance-window"
+
+	// restartPolicyAnnotation on a ServiceAccount overrides the global restart policy.
+	restartPolicyAnnotation = "clientid-operator/restart-policy"
+	// pendingRestartAnnotation records on a ServiceAccount when its client ID changed while
+	// its workloads still await a restart, so pending restarts survive operator restarts.
+	pendingRestartAnnotation = "clientid-operator/pending-restart"
+
+	// staggeredRestartInterval is how often a staggered restart checks on the current rollout.
+	staggeredRestartInterval = 30 * time.Second
+)
+
+// ValidateRestartPolicy checks that policy is known and has the configuration it needs.
+func ValidateRestartPolicy(policy string, window *MaintenanceWindow) error {
+	switch policy {
+	case RestartPolicyNever, RestartPolicyImmediate, RestartPolicyStaggered:
+		return nil
+	case RestartPolicyMaintenanceWindow:
+		if window == nil {
+			return fmt.Errorf("restart policy %s requires a maintenance window", policy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown restart policy %q", policy)
+	}
+}
+
+// restartPolicy returns the restart policy of sa, falling back to the global policy
+// when the ServiceAccount has no valid policy of its own.
+func (r *UserAssignedIdentityReconciler) restartPolicy(sa *corev1.ServiceAccount) string {
+	global := r.RestartPolicy
+	if global == "" {
+		global = RestartPolicyImmediate
+	}
+	policy, ok := sa.Annotations[restartPolicyAnnotation]
+	if !ok {
+		return global
+	}
+	if err := ValidateRestartPolicy(policy, r.MaintenanceWindow); err != nil {
+		r.event(sa, corev1.EventTypeWarning, "InvalidRestartPolicy", fmt.Sprintf("Ignoring %s: %v, using %s", restartPolicyAnnotation, err, global))
+		return global
+	}
+	return policy
+}
+
+// restartServiceAccountWorkloads restarts the workloads using sa according to its restart
+// policy. changed marks a new client ID on sa; restarts that cannot complete right away, or
+// while identity has its restarts paused, are recorded on the ServiceAccount and resumed by
+// later reconciles.
+func (r *UserAssignedIdentityReconciler) restartServiceAccountWorkloads(ctx context.Context, sa *corev1.ServiceAccount, identity workloadIdentity, changed bool, result *serviceAccountSync, log logr.Logger) error {
+	log = log.WithValues("ServiceAccount", client.ObjectKeyFromObject(sa))
+	policy := r.restartPolicy(sa)
+
+	pending := sa.Annotations[pendingRestartAnnotation]
+	if changed {
+		pending = time.Now().UTC().Format(time.RFC3339)
+	}
+	since, err := time.Parse(time.RFC3339, pending)
+	if err != nil {
+		// Restarting everything since now would restart workloads already on the client ID
+		log.Info("Dropping unparsable pending restart", "value", pending, "error", err)
+		r.event(sa, corev1.EventTypeWarning, "InvalidPendingRestart",
+			fmt.Sprintf("Removing %s %q, which is not an RFC 3339 time; no workloads are restarted", pendingRestartAnnotation, pending))
+		return r.setPendingRestart(ctx, sa, "")
+	}
+
+	if paused := restartsPaused(identity.Owner); paused != "" {
+		if changed {
+			log.Info("Workload restarts are paused", "reason", paused)
+			r.event(sa, corev1.EventTypeWarning, "RestartPaused",
+				fmt.Sprintf("Workload restarts are paused by %s on %s: %s", restartsPausedAnnotation, identity.Owner.GetName(), paused))
+		}
+		result.PendingRestarts = append(result.PendingRestarts, client.ObjectKeyFromObject(sa))
+		return r.setPendingRestart(ctx, sa, pending)
+	}
+
+	// Without stale pod detection every workload using sa is restarted
+	var only workloadSet
+	if r.RestartStalePodsOnly {
+		if only, err = r.staleWorkloads(ctx, sa, since, log); err != nil {
+			return err
+		}
+	}
+
+	done := true
+	switch policy {
+	case RestartPolicyNever:
+	case RestartPolicyImmediate:
+		done, err = r.restartWorkloads(ctx, sa.Name, sa.Namespace, since, only, result, log)
+	case RestartPolicyStaggered:
+		done, err = r.restartNextWorkload(ctx, sa, since, only, result, log)
+		if !done {
+			result.requeue(staggeredRestartInterval)
+		}
+	case RestartPolicyMaintenanceWindow:
+		now := time.Now()
+		if r.MaintenanceWindow.Open(now) {
+			done, err = r.restartWorkloads(ctx, sa.Name, sa.Namespace, since, only, result, log)
+			break
+		}
+		done = false
+		if next, ok := r.MaintenanceWindow.Next(now); ok {
+			result.requeue(next.Sub(now))
+		}
+		if changed {
+			log.Info("Deferring workload restarts to the maintenance window", "schedule", r.MaintenanceWindow.Schedule)
+			r.event(sa, corev1.EventTypeNormal, "RestartDeferred", fmt.Sprintf("Workload restarts deferred to maintenance window %q", r.MaintenanceWindow.Schedule))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if done {
+		pending = ""
+	} else {
+		result.PendingRestarts = append(result.PendingRestarts, client.ObjectKeyFromObject(sa))
+	}
+	return r.setPendingRestart(ctx, sa, pending)
+}
+
+// restartNextWorkload restarts one workload in only using sa that has not been restarted since
+// the client ID changed, after the rollout of the previously restarted workload has completed.
+// It reports true once every workload has been restarted.
+func (r *UserAssignedIdentityReconciler) restartNextWorkload(ctx context.Context, sa *corev1.ServiceAccount, since time.Time, only workloadSet, result *serviceAccountSync, log logr.Logger) (bool, error) {
+	for _, kind := range r.workloads() {
+		workloads, err := r.workloadsUsing(ctx, kind, sa.Name, sa.Namespace)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", kind.kind, err)
+		}
+		for _, workload := range workloads {
+			restarted, err := r.restartStrategyFor(workload).restarted(ctx, r, kind, workload, since)
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", kind.kind, err)
+			}
+			if restarted {
+				if !kind.rolledOut(workload) {
+					log.V(1).Info("Waiting for rollout before restarting the next workload", "kind", kind.kind, "name", workload.GetName())
+					return false, nil
+				}
+				continue
+			}
+			if !only.has(kind.kind, workload) {
+				continue
+			}
+			_, err = r.restartWorkload(ctx, kind, workload, sa.Name, since, result, log)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// setPendingRestart records pending on sa, or removes the record when pending is empty.
+func (r *UserAssignedIdentityReconciler) setPendingRestart(ctx context.Context, sa *corev1.ServiceAccount, pending string) error {
+	if sa.Annotations[pendingRestartAnnotation] == pending {
+		return nil
+	}
+	patch := client.MergeFrom(sa.DeepCopy())
+	if pending == "" {
+		delete(sa.Annotations, pendingRestartAnnotation)
+	} else {
+		if sa.Annotations == nil {
+			sa.Annotations = map[string]string{}
+		}
+		sa.Annotations[pendingRestartAnnotation] = pending
+	}
+	return r.Patch(ctx, sa, patch)
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const restartTestSA = "workload-identity-testapp"
+
+// rolledOutDeployment returns a Deployment using restartTestSA whose single replica is up to date.
+func rolledOutDeployment(name string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To[int32](1), Template: podTemplate(restartTestSA)},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+}
+
+// restartPolicyObjects is a ServiceAccount with the given annotations and two Deployments using it.
+func restartPolicyObjects(annotations map[string]string) []client.Object {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: restartTestSA, Namespace: "default", Annotations: annotations}}
+	return []client.Object{sa, rolledOutDeployment("first"), rolledOutDeployment("second")}
+}
+
+func syncServiceAccount(t *testing.T, r *UserAssignedIdentityReconciler, clientID string) serviceAccountSync {
+	t.Helper()
+	key := types.NamespacedName{Name: restartTestSA, Namespace: "default"}
+	result, err := r.updateServiceAccounts(context.Background(), []types.NamespacedName{key}, workloadIdentity{ClientID: clientID}, r.Log)
+	if err != nil {
+		t.Fatalf("updateServiceAccounts() error = %v", err)
+	}
+	return result
+}
+
+func restarted(t *testing.T, cl client.Client, name string) bool {
+	t.Helper()
+	var deployment appsv1.Deployment
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, &deployment); err != nil {
+		t.Fatalf("Failed to get Deployment: %v", err)
+	}
+	_, ok := deployment.Spec.Template.Annotations[restartAnnotation]
+	return ok
+}
+
+func pendingRestart(t *testing.T, cl client.Client) string {
+	t.Helper()
+	var sa corev1.ServiceAccount
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: restartTestSA, Namespace: "default"}, &sa); err != nil {
+		t.Fatalf("Failed to get ServiceAccount: %v", err)
+	}
+	return sa.Annotations[pendingRestartAnnotation]
+}
+
+func TestValidateRestartPolicy(t *testing.T) {
+	window, _ := ParseMaintenanceWindow("0 2 * * *", time.Hour)
+	tests := []struct {
+		policy  string
+		window  *MaintenanceWindow
+		wantErr bool
+	}{
+		{policy: RestartPolicyNever},
+		{policy: RestartPolicyImmediate},
+		{policy: RestartPolicyStaggered},
+		{policy: RestartPolicyMaintenanceWindow, window: window},
+		{policy: RestartPolicyMaintenanceWindow, wantErr: true},
+		{policy: "eventually", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateRestartPolicy(tt.policy, tt.window); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRestartPolicy(%q) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRestartPolicy_Never(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, restartPolicyObjects(map[string]string{restartPolicyAnnotation: RestartPolicyNever})...)
+
+	result := syncServiceAccount(t, r, "new-client-id")
+	if len(result.PatchedServiceAccounts) != 1 || len(result.RestartedWorkloads) != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if restarted(t, cl, "first") || restarted(t, cl, "second") {
+		t.Error("Deployment restarted despite restart policy never")
+	}
+	if pending := pendingRestart(t, cl); pending != "" {
+		t.Errorf("Expected no pending restart, got %s", pending)
+	}
+}
+
+func TestRestartPolicy_InvalidPendingRestart(t *testing.T) {
+	cl, r, recorder := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "test-client-id", pendingRestartAnnotation: "yesterday"})...)
+
+	result := syncServiceAccount(t, r, "test-client-id")
+	if restarted(t, cl, "first") || restarted(t, cl, "second") || len(result.RestartedWorkloads) != 0 {
+		t.Error("Expected an unparsable pending restart not to restart any workload")
+	}
+	if pending := pendingRestart(t, cl); pending != "" {
+		t.Errorf("Expected the unparsable pending restart to be removed, got %s", pending)
+	}
+	if events := strings.Join(drainEvents(recorder), "\n"); !strings.Contains(events, "Warning InvalidPendingRestart") {
+		t.Errorf("Expected an InvalidPendingRestart event, got:\n%s", events)
+	}
+}
+
+func TestRestartPolicy_Staggered(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, restartPolicyObjects(nil)...)
+	r.RestartPolicy = RestartPolicyStaggered
+
+	// The client ID change restarts the first Deployment only
+	result := syncServiceAccount(t, r, "new-client-id")
+	if !restarted(t, cl, "first") || restarted(t, cl, "second") {
+		t.Fatal("Expected only the first Deployment to be restarted")
+	}
+	if len(result.PendingRestarts) != 1 || result.RequeueAfter != staggeredRestartInterval {
+		t.Errorf("Expected a pending restart requeued after %s, got %s", staggeredRestartInterval, result.RequeueAfter)
+	}
+	if pendingRestart(t, cl) == "" {
+		t.Fatal("Expected the pending restart to be recorded on the ServiceAccount")
+	}
+
+	// The second Deployment waits until the first one has rolled out
+	var first appsv1.Deployment
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: "first", Namespace: "default"}, &first); err != nil {
+		t.Fatalf("Failed to get Deployment: %v", err)
+	}
+	first.Status.UpdatedReplicas = 0
+	if err := cl.Status().Update(context.Background(), &first); err != nil {
+		t.Fatalf("Failed to update Deployment: %v", err)
+	}
+	syncServiceAccount(t, r, "new-client-id")
+	if restarted(t, cl, "second") {
+		t.Fatal("Second Deployment restarted before the first one rolled out")
+	}
+
+	first.Status.UpdatedReplicas = 1
+	if err := cl.Status().Update(context.Background(), &first); err != nil {
+		t.Fatalf("Failed to update Deployment: %v", err)
+	}
+	syncServiceAccount(t, r, "new-client-id")
+	if !restarted(t, cl, "second") {
+		t.Fatal("Expected the second Deployment to be restarted")
+	}
+
+	// Once every Deployment has rolled out the pending restart is cleared
+	result = syncServiceAccount(t, r, "new-client-id")
+	if len(result.PendingRestarts) != 0 || len(result.RestartedWorkloads) != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if pending := pendingRestart(t, cl); pending != "" {
+		t.Errorf("Expected pending restart to be cleared, got %s", pending)
+	}
+}
+
+func TestRestartPolicy_MaintenanceWindow(t *testing.T) {
+	cl, r, recorder := newTestReconciler(t, restartPolicyObjects(map[string]string{restartPolicyAnnotation: RestartPolicyMaintenanceWindow})...)
+	// A window that opened an hour ago and has already closed
+	opened := time.Now().UTC().Add(-time.Hour)
+	closed, err := ParseMaintenanceWindow(opened.Format("4 15 * * *"), time.Minute)
+	if err != nil {
+		t.Fatalf("ParseMaintenanceWindow() error = %v", err)
+	}
+	r.MaintenanceWindow = closed
+
+	result := syncServiceAccount(t, r, "new-client-id")
+	if restarted(t, cl, "first") || restarted(t, cl, "second") {
+		t.Fatal("Deployment restarted outside the maintenance window")
+	}
+	if len(result.PendingRestarts) != 1 || pendingRestart(t, cl) == "" {
+		t.Fatal("Expected the deferred restart to be recorded on the ServiceAccount")
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Normal ClientIDUpdated") {
+		t.Errorf("Expected a ClientIDUpdated event, got %q", event)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Normal RestartDeferred") {
+		t.Errorf("Expected a RestartDeferred event, got %q", event)
+	}
+
+	// The pending restart is picked up once the window opens, without another client ID change
+	open, err := ParseMaintenanceWindow("* * * * *", time.Minute)
+	if err != nil {
+		t.Fatalf("ParseMaintenanceWindow() error = %v", err)
+	}
+	r.MaintenanceWindow = open
+	result = syncServiceAccount(t, r, "new-client-id")
+	if len(result.RestartedWorkloads) != 2 || !restarted(t, cl, "first") || !restarted(t, cl, "second") {
+		t.Errorf("Expected both Deployments to be restarted in the window, got %+v", result)
+	}
+	if pending := pendingRestart(t, cl); pending != "" {
+		t.Errorf("Expected pending restart to be cleared, got %s", pending)
+	}
+}
+
+func TestRestartPolicy_InvalidAnnotation(t *testing.T) {
+	cl, r, recorder := newTestReconciler(t, restartPolicyObjects(map[string]string{restartPolicyAnnotation: "eventually"})...)
+
+	syncServiceAccount(t, r, "new-client-id")
+	if !restarted(t, cl, "first") || !restarted(t, cl, "second") {
+		t.Error("Expected the global immediate policy to restart both Deployments")
+	}
+	close(recorder.Events)
+	found := false
+	for event := range recorder.Events {
+		if strings.HasPrefix(event, "Warning InvalidRestartPolicy") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected an InvalidRestartPolicy event")
+	}
+}
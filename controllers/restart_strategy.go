@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RestartStrategyTemplateAnnotation sets azure.workload.identity/restart on the pod
+	// template, which rolls out the workload.
+	RestartStrategyTemplateAnnotation = "template-annotation"
+	// RestartStrategyRestartedAt sets kubectl.kubernetes.io/restartedAt on the pod template,
+	// like kubectl rollout restart, which rolls out the workload.
+	RestartStrategyRestartedAt = "restarted-at"
+	// RestartStrategyEvict evicts the pods of the workload through the Eviction API, which
+	// respects their PodDisruptionBudgets, and lets their controller replace them.
+	RestartStrategyEvict = "evict"
+
+	// restartStrategyAnnotation on a workload overrides the global restart strategy.
+	restartStrategyAnnotation = "clientid-operator/restart-strategy"
+	restartedAtAnnotation     = "kubectl.kubernetes.io/restartedAt"
+
+	// evictionRetryInterval is how often evictions blocked by a PodDisruptionBudget are retried.
+	evictionRetryInterval = 30 * time.Second
+)
+
+// restartStrategy restarts the pods of a workload so they pick up a new client ID.
+type restartStrategy interface {
+	// restart restarts the pods of workload started before since. It reports false when
+	// pods are left that could not be restarted yet.
+	restart(ctx context.Context, r *UserAssignedIdentityReconciler, kind workloadKind, workload client.Object, since time.Time) (bool, error)
+	// restarted reports whether workload has been restarted since.
+	restarted(ctx context.Context, r *UserAssignedIdentityReconciler, kind workloadKind, workload client.Object, since time.Time) (bool, error)
+}
+
+var restartStrategies = map[string]restartStrategy{
+	RestartStrategyTemplateAnnotation: templateRestart{annotation: restartAnnotation},
+	RestartStrategyRestartedAt:        templateRestart{annotation: restartedAtAnnotation},
+	RestartStrategyEvict:              evictionRestart{},
+}
+
+// ValidateRestartStrategy checks that strategy is a known restart strategy.
+func ValidateRestartStrategy(strategy string) error {
+	if _, ok := restartStrategies[strategy]; !ok {
+		return fmt.Errorf("unknown restart strategy %q, must be %s, %s or %s", strategy,
+			RestartStrategyTemplateAnnotation, RestartStrategyRestartedAt, RestartStrategyEvict)
+	}
+	return nil
+}
+
+// restartStrategyFor returns the restart strategy of workload, falling back to the global
+// strategy when the workload has no valid strategy of its own.
+func (r *UserAssignedIdentityReconciler) restartStrategyFor(workload client.Object) restartStrategy {
+	global := r.RestartStrategy
+	if global == "" {
+		global = RestartStrategyTemplateAnnotation
+	}
+	name, ok := workload.GetAnnotations()[restartStrategyAnnotation]
+	if !ok {
+		return restartStrategies[global]
+	}
+	strategy, ok := restartStrategies[name]
+	if !ok {
+		r.event(workload, corev1.EventTypeWarning, "InvalidRestartStrategy",
+			fmt.Sprintf("Ignoring %s: %v, using %s", restartStrategyAnnotation, ValidateRestartStrategy(name), global))
+		return restartStrategies[global]
+	}
+	return strategy
+}
+
+// templateRestart restarts a workload by setting a timestamp annotation on its pod template.
+type templateRestart struct {
+	annotation string
+}
+
+func (s templateRestart) restart(ctx context.Context, r *UserAssignedIdentityReconciler, kind workloadKind, workload client.Object, _ time.Time) (bool, error) {
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	now := time.Now()
+	if err := kind.annotateTemplate(workload, s.annotation, now.Format(time.RFC3339)); err != nil {
+		return false, err
+	}
+	// Follow the rollout from the same patch
+	annotations := workload.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[rolloutStartedAnnotation] = now.UTC().Format(time.RFC3339)
+	workload.SetAnnotations(annotations)
+	// Label in the same patch, so the workload is not rolled out a second time for it
+	if r.UseLabel {
+		if err := kind.labelTemplate(workload, useLabel, "true"); err != nil {
+			return false, err
+		}
+	}
+	return true, r.Patch(ctx, workload, patch)
+}
+
+func (s templateRestart) restarted(_ context.Context, _ *UserAssignedIdentityReconciler, kind workloadKind, workload client.Object, since time.Time) (bool, error) {
+	restartedAt, err := time.Parse(time.RFC3339, kind.templateAnnotation(workload, s.annotation))
+	return err == nil && !restartedAt.Before(since), nil
+}
+
+// evictionRestart restarts a workload by evicting its pods started before the client ID changed.
+type evictionRestart struct{}
+
+func (evictionRestart) restart(ctx context.Context, r *UserAssignedIdentityReconciler, kind workloadKind, workload client.Object, since time.Time) (bool, error) {
+	pods, err := r.podsStartedBefore(ctx, kind, workload, since)
+	if err != nil {
+		return false, err
+	}
+	blocked := 0
+	for _, pod := range pods {
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		if err := r.SubResource("eviction").Create(ctx, &pod, eviction); err != nil {
+			switch {
+			case errors.IsNotFound(err):
+			case errors.IsTooManyRequests(err):
+				// The eviction would violate a PodDisruptionBudget
+				blocked++
+			default:
+				return false, fmt.Errorf("failed to evict pod %s: %w", pod.Name, err)
+			}
+		}
+	}
+	if blocked > 0 {
+		r.event(workload, corev1.EventTypeNormal, "EvictionBlocked",
+			fmt.Sprintf("%d pod(s) cannot be evicted yet without violating a PodDisruptionBudget, retrying", blocked))
+		return false, nil
+	}
+	return true, nil
+}
+
+func (evictionRestart) restarted(ctx context.Context, r *UserAssignedIdentityReconciler, kind workloadKind, workload client.Object, since time.Time) (bool, error) {
+	pods, err := r.podsStartedBefore(ctx, kind, workload, since)
+	return len(pods) == 0, err
+}
+
+// podsStartedBefore lists the running pods of workload created before since.
+func (r *UserAssignedIdentityReconciler) podsStartedBefore(ctx context.Context, kind workloadKind, workload client.Object, since time.Time) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	saName := kind.serviceAccountName(workload)
+	if err := r.List(ctx, &pods, client.InNamespace(workload.GetNamespace()), client.MatchingFields{podServiceAccountIndex: saName}); err != nil {
+		return nil, err
+	}
+	ref := workloadRef{Kind: kind.kind, NamespacedName: client.ObjectKeyFromObject(workload)}
+	var started []corev1.Pod
+	for _, pod := range pods.Items {
+		if !pod.CreationTimestamp.Time.Before(since) || !pod.DeletionTimestamp.IsZero() ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		owner, ok, err := r.podWorkload(ctx, &pod)
+		if err != nil {
+			return nil, err
+		}
+		if ok && owner == ref {
+			started = append(started, pod)
+		}
+	}
+	return started, nil
+}
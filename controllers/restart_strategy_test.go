@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestValidateRestartStrategy(t *testing.T) {
+	for _, strategy := range []string{RestartStrategyTemplateAnnotation, RestartStrategyRestartedAt, RestartStrategyEvict} {
+		if err := ValidateRestartStrategy(strategy); err != nil {
+			t.Errorf("ValidateRestartStrategy(%s) error = %v", strategy, err)
+		}
+	}
+	if err := ValidateRestartStrategy("rollout"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
+
+func TestRestartStrategyFor(t *testing.T) {
+	recorder := record.NewFakeRecorder(5)
+	r := &UserAssignedIdentityReconciler{Recorder: recorder, RestartStrategy: RestartStrategyRestartedAt}
+	workload := func(strategy string) client.Object {
+		deployment := rolledOutDeployment("api")
+		if strategy != "" {
+			deployment.Annotations = map[string]string{restartStrategyAnnotation: strategy}
+		}
+		return deployment
+	}
+
+	if got := r.restartStrategyFor(workload("")); got != restartStrategies[RestartStrategyRestartedAt] {
+		t.Errorf("Expected the global strategy, got %#v", got)
+	}
+	if got := r.restartStrategyFor(workload(RestartStrategyEvict)); got != restartStrategies[RestartStrategyEvict] {
+		t.Errorf("Expected the annotated strategy, got %#v", got)
+	}
+	if got := r.restartStrategyFor(workload("rollout")); got != restartStrategies[RestartStrategyRestartedAt] {
+		t.Errorf("Expected the global strategy for an invalid annotation, got %#v", got)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning InvalidRestartStrategy") {
+		t.Errorf("Expected an InvalidRestartStrategy event, got %q", event)
+	}
+}
+
+func TestRestartStrategy_RestartedAt(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "old-client-id"})...)
+	ctx := context.Background()
+	var second appsv1.Deployment
+	_ = cl.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &second)
+	second.Annotations = map[string]string{restartStrategyAnnotation: RestartStrategyRestartedAt}
+	if err := cl.Update(ctx, &second); err != nil {
+		t.Fatalf("Failed to annotate Deployment: %v", err)
+	}
+
+	syncServiceAccount(t, r, "new-client-id")
+	if !restarted(t, cl, "first") {
+		t.Error("Expected the Deployment without a strategy to get the restart annotation")
+	}
+	_ = cl.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &second)
+	if _, ok := second.Spec.Template.Annotations[restartedAtAnnotation]; !ok {
+		t.Errorf("Expected %s on the pod template, got %v", restartedAtAnnotation, second.Spec.Template.Annotations)
+	}
+	if _, ok := second.Spec.Template.Annotations[restartAnnotation]; ok {
+		t.Errorf("Expected no %s on the pod template", restartAnnotation)
+	}
+}
+
+func TestRestartStrategy_Evict(t *testing.T) {
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name: restartTestSA, Namespace: "default", Annotations: map[string]string{clientIDAnnotation: "old-client-id"},
+	}}
+	deployment := rolledOutDeployment("api")
+	deployment.Annotations = map[string]string{restartStrategyAnnotation: RestartStrategyEvict}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-abc", Namespace: "default"}}
+	ownedBy(replicaSet, deployment, "Deployment")
+	objs := []client.Object{sa, deployment, replicaSet}
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"api-abc-1", "api-abc-2"} {
+		pod := podWithClientID(name, "old-client-id", old)
+		ownedBy(pod, replicaSet, "ReplicaSet")
+		objs = append(objs, pod)
+	}
+	unrelated := podWithClientID("other", "old-client-id", old)
+	objs = append(objs, unrelated)
+
+	// api-abc-2 is protected by a PodDisruptionBudget until protected is cleared
+	protected := true
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, sub client.Object, opts ...client.SubResourceCreateOption) error {
+				if subResource == "eviction" && obj.GetName() == "api-abc-2" && protected {
+					return errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+				}
+				return c.SubResource(subResource).Create(ctx, obj, sub, opts...)
+			},
+		}).
+		Build()
+	recorder := record.NewFakeRecorder(20)
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), Recorder: recorder}
+	ctx := context.Background()
+	exists := func(name string) bool {
+		var pod corev1.Pod
+		return cl.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, &pod) == nil
+	}
+
+	result := syncServiceAccount(t, r, "new-client-id")
+	if exists("api-abc-1") || !exists("api-abc-2") || !exists("other") {
+		t.Errorf("Expected only the unprotected pod of the Deployment to be evicted")
+	}
+	if restarted(t, cl, "api") {
+		t.Error("Expected the pod template to be left alone")
+	}
+	if pendingRestart(t, cl) == "" || result.RequeueAfter != evictionRetryInterval {
+		t.Errorf("Expected a pending restart retried in %v, got %v", evictionRetryInterval, result.RequeueAfter)
+	}
+	// The workload only counts as restarted once all of its old pods are gone
+	if len(result.RestartedWorkloads) != 0 {
+		t.Errorf("Expected no restarted workloads while an eviction is blocked, got %v", result.RestartedWorkloads)
+	}
+	events := strings.Join(drainEvents(recorder), "\n")
+	if !strings.Contains(events, "Normal EvictionBlocked") || strings.Contains(events, "Normal Restarted") {
+		t.Errorf("Expected an EvictionBlocked event and no Restarted event, got:\n%s", events)
+	}
+
+	protected = false
+	result = syncServiceAccount(t, r, "new-client-id")
+	if exists("api-abc-2") {
+		t.Error("Expected the pod to be evicted once the budget allows it")
+	}
+	if pendingRestart(t, cl) != "" {
+		t.Error("Expected the pending restart to be cleared")
+	}
+	if len(result.RestartedWorkloads) != 1 || !strings.Contains(strings.Join(drainEvents(recorder), "\n"), "Normal Restarted") {
+		t.Errorf("Expected the workload to be reported restarted once its pods are evicted, got %v", result.RestartedWorkloads)
+	}
+	// Evictions roll out no new revision to follow
+	if followed(t, cl, "api") {
+		t.Error("Expected no rollout to be followed after evicting")
+	}
+}
+
+func TestRestartStrategy_Failed(t *testing.T) {
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name: restartTestSA, Namespace: "default", Annotations: map[string]string{clientIDAnnotation: "old-client-id"},
+	}}
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(sa, rolledOutDeployment("api")).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if _, ok := obj.(*appsv1.Deployment); ok {
+					return errors.NewForbidden(appsv1.Resource("deployments"), obj.GetName(), nil)
+				}
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+	recorder := record.NewFakeRecorder(20)
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), Recorder: recorder}
+
+	result := syncServiceAccount(t, r, "new-client-id")
+	if len(result.RestartedWorkloads) != 0 || pendingRestart(t, cl) == "" {
+		t.Errorf("Expected a failed restart to stay pending, got restarted %v", result.RestartedWorkloads)
+	}
+	events := strings.Join(drainEvents(recorder), "\n")
+	if !strings.Contains(events, "Warning RestartFailed") || strings.Contains(events, "Normal Restarted") {
+		t.Errorf("Expected a RestartFailed event and no Restarted event, got:\n%s", events)
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+)
+
+const (
+	// RoleAssignmentScopeIdentity wires namespaced identities to the namespaced RoleAssignments
+	// in their namespace. Cluster-scoped identities serve every namespace, so they keep being
+	// wired to the RoleAssignments of both scopes in all namespaces.
+	RoleAssignmentScopeIdentity = "identity"
+	// RoleAssignmentScopeCluster wires an identity to the RoleAssignments of its app in
+	// both scopes and all namespaces.
+	RoleAssignmentScopeCluster = "cluster"
+)
+
+// ValidateRoleAssignmentScope checks that scope is a known RoleAssignment scope.
+func ValidateRoleAssignmentScope(scope string) error {
+	switch scope {
+	case RoleAssignmentScopeIdentity, RoleAssignmentScopeCluster:
+		return nil
+	default:
+		return fmt.Errorf("unknown RoleAssignment scope %q, must be %s or %s", scope, RoleAssignmentScopeIdentity, RoleAssignmentScopeCluster)
+	}
+}
+
+// roleAssignmentScope is where RoleAssignments are looked up.
+type roleAssignmentScope struct {
+	// Namespaced includes namespaced RoleAssignments, only those in Namespace when it is set.
+	Namespaced bool
+	Namespace  string
+	// Cluster includes cluster-scoped RoleAssignments.
+	Cluster bool
+}
+
+// allRoleAssignments looks up RoleAssignments in both scopes and all namespaces.
+var allRoleAssignments = roleAssignmentScope{Namespaced: true, Cluster: true}
+
+// roleAssignmentScopeFor returns where the RoleAssignments of identity are looked up by
+// naming convention under the configured RoleAssignmentScope. Only namespaced identities
+// are narrowed to their namespace.
+func (r *UserAssignedIdentityReconciler) roleAssignmentScopeFor(identity client.Object) roleAssignmentScope {
+	if r.RoleAssignmentScope == RoleAssignmentScopeCluster {
+		return allRoleAssignments
+	}
+	if identity.GetNamespace() == "" {
+		return allRoleAssignments
+	}
+	return roleAssignmentScope{Namespaced: true, Namespace: identity.GetNamespace()}
+}
+
+// listRoleAssignments returns the RoleAssignments in scope matched by selector. A scope whose
+// RoleAssignment CRD is not installed is skipped.
+func (r *UserAssignedIdentityReconciler) listRoleAssignments(ctx context.Context, selector labels.Selector, scope roleAssignmentScope, log logr.Logger) []client.Object {
+	var roleAssignments []client.Object
+	if scope.Namespaced {
+		opts := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+		if scope.Namespace != "" {
+			opts = append(opts, client.InNamespace(scope.Namespace))
+		}
+		var namespaced ra.RoleAssignmentList
+		if err := r.List(ctx, &namespaced, opts...); err != nil {
+			log.V(1).Info("Could not list namespaced RoleAssignments", "error", err)
+		}
+		for i := range namespaced.Items {
+			roleAssignments = append(roleAssignments, &namespaced.Items[i])
+		}
+	}
+	if scope.Cluster {
+		var cluster ra2.RoleAssignmentList
+		if err := r.List(ctx, &cluster, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			log.V(1).Info("Could not list cluster-scoped RoleAssignments", "error", err)
+		}
+		for i := range cluster.Items {
+			roleAssignments = append(roleAssignments, &cluster.Items[i])
+		}
+	}
+	return roleAssignments
+}
+
+// rolePrincipalID returns the principal ID a RoleAssignment of either scope points at.
+func rolePrincipalID(roleAssignment client.Object) *string {
+	switch roleAssignment := roleAssignment.(type) {
+	case *ra.RoleAssignment:
+		return roleAssignment.Spec.ForProvider.PrincipalID
+	case *ra2.RoleAssignment:
+		return roleAssignment.Spec.ForProvider.PrincipalID
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// roleAssignmentScopeObjects are identities of the same app in team-a and team-b, each with
+// a RoleAssignment in its namespace, and a cluster-scoped RoleAssignment of the app.
+func roleAssignmentScopeObjects() []client.Object {
+	appLabels := map[string]string{"application": "testapp", "type": "roleassignment"}
+	var objs []client.Object
+	for _, namespace := range []string{"team-a", "team-b"} {
+		identity := identityNamed("testapp", namespace, "id-service-testapp-dv-azunea-001")
+		identity.Status.AtProvider.ClientID = ptr.To(namespace + "-client-id")
+		identity.Status.AtProvider.PrincipalID = ptr.To(namespace + "-principal-id")
+		objs = append(objs, identity, &ra.RoleAssignment{
+			ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp", Namespace: namespace, Labels: appLabels},
+			Spec:       ra.RoleAssignmentSpec{ForProvider: ra.RoleAssignmentParameters{PrincipalID: ptr.To(namespace + "-principal-id")}},
+		})
+	}
+	return append(objs, &ra2.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "ra-testapp-cluster", Labels: appLabels},
+		Spec:       ra2.RoleAssignmentSpec{ForProvider: ra2.RoleAssignmentParameters{PrincipalID: ptr.To("old-principal-id")}},
+	})
+}
+
+func TestRoleAssignmentScope(t *testing.T) {
+	tests := []struct {
+		scope string
+		// want is the principal ID of each RoleAssignment after reconciling team-a's identity
+		want map[string]string
+	}{
+		{
+			scope: RoleAssignmentScopeIdentity,
+			want: map[string]string{
+				"team-a/ra-testapp":  "team-a-principal-id",
+				"team-b/ra-testapp":  "team-b-principal-id",
+				"ra-testapp-cluster": "old-principal-id",
+			},
+		},
+		{
+			scope: RoleAssignmentScopeCluster,
+			want: map[string]string{
+				"team-a/ra-testapp":  "team-a-principal-id",
+				"team-b/ra-testapp":  "team-a-principal-id",
+				"ra-testapp-cluster": "team-a-principal-id",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			cl, r, _ := newTestReconciler(t, roleAssignmentScopeObjects()...)
+			r.RoleAssignmentScope = tt.scope
+			ctx := context.Background()
+			key := types.NamespacedName{Name: "testapp", Namespace: "team-a"}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			outOfSyncIdentities.forget(key)
+
+			for _, namespace := range []string{"team-a", "team-b"} {
+				var roleAssignment ra.RoleAssignment
+				_ = cl.Get(ctx, types.NamespacedName{Name: "ra-testapp", Namespace: namespace}, &roleAssignment)
+				name := namespace + "/ra-testapp"
+				if got := ptr.Deref(roleAssignment.Spec.ForProvider.PrincipalID, ""); got != tt.want[name] {
+					t.Errorf("RoleAssignment %s PrincipalID incorrect. Expected %s, got %s", name, tt.want[name], got)
+				}
+			}
+			var cluster ra2.RoleAssignment
+			_ = cl.Get(ctx, types.NamespacedName{Name: "ra-testapp-cluster"}, &cluster)
+			if got := ptr.Deref(cluster.Spec.ForProvider.PrincipalID, ""); got != tt.want["ra-testapp-cluster"] {
+				t.Errorf("Cluster-scoped RoleAssignment PrincipalID incorrect. Expected %s, got %s", tt.want["ra-testapp-cluster"], got)
+			}
+		})
+	}
+}
+
+func TestRoleAssignmentScope_ClusterIdentity(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, roleAssignmentScopeObjects()...)
+	ctx := context.Background()
+	azureName := "id-service-clusterapp-dv-azunea-001"
+	identity := &mi2.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: "clusterapp"},
+		Spec:       mi2.UserAssignedIdentitySpec{ForProvider: mi2.UserAssignedIdentityParameters{Name: &azureName}},
+		Status: mi2.UserAssignedIdentityStatus{AtProvider: mi2.UserAssignedIdentityObservation{
+			ClientID: ptr.To("cluster-client-id"), PrincipalID: ptr.To("cluster-principal-id"),
+		}},
+	}
+	roleAssignment := &ra.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "ra-clusterapp", Namespace: "team-a",
+			Labels: map[string]string{"application": "clusterapp", "type": "roleassignment"}},
+		Spec: ra.RoleAssignmentSpec{ForProvider: ra.RoleAssignmentParameters{PrincipalID: ptr.To("old-principal-id")}},
+	}
+	for _, obj := range []client.Object{identity, roleAssignment} {
+		if err := cl.Create(ctx, obj); err != nil {
+			t.Fatalf("Failed to create %s: %v", obj.GetName(), err)
+		}
+	}
+
+	key := types.NamespacedName{Name: "clusterapp"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	outOfSyncIdentities.forget(key)
+
+	// A cluster-scoped identity is still wired to the namespaced RoleAssignments of its app
+	var got ra.RoleAssignment
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(roleAssignment), &got); err != nil {
+		t.Fatalf("Failed to get RoleAssignment: %v", err)
+	}
+	if principalID := ptr.Deref(got.Spec.ForProvider.PrincipalID, ""); principalID != "cluster-principal-id" {
+		t.Errorf("RoleAssignment PrincipalID incorrect. Expected cluster-principal-id, got %s", principalID)
+	}
+}
+
+func TestAppNameConflict(t *testing.T) {
+	_, r, recorder := newTestReconciler(t, roleAssignmentScopeObjects()...)
+	key := types.NamespacedName{Name: "testapp", Namespace: "team-a"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	outOfSyncIdentities.forget(key)
+
+	found := false
+	for len(recorder.Events) > 0 {
+		event := <-recorder.Events
+		if strings.HasPrefix(event, "Warning AppNameConflict") {
+			found = true
+			if !strings.Contains(event, "team-b/testapp") {
+				t.Errorf("Expected the conflicting identity in the event, got %q", event)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected an AppNameConflict event")
+	}
+}
+
+func TestValidateRoleAssignmentScope(t *testing.T) {
+	for _, scope := range []string{RoleAssignmentScopeIdentity, RoleAssignmentScopeCluster} {
+		if err := ValidateRoleAssignmentScope(scope); err != nil {
+			t.Errorf("ValidateRoleAssignmentScope(%s) error = %v", scope, err)
+		}
+	}
+	if err := ValidateRoleAssignmentScope("namespace"); err == nil {
+		t.Error("Expected an error for an unknown scope")
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// rolloutStartedAnnotation records on a workload when the operator restarted it, for as
+	// long as its rollout is followed. It is set by the patch changing the pod template, so
+	// evictions, which roll out no new revision, are not followed.
+	rolloutStartedAnnotation = "clientid-operator/rollout-started"
+	// restartsPausedAnnotation on a UserAssignedIdentity or IdentityBinding pauses the restarts
+	// of its workloads. It is set with the reason when a rollout fails and
+	// PauseRestartsOnRolloutFailure is set, and removed by hand to resume the restarts.
+	restartsPausedAnnotation = "clientid-operator/restarts-paused"
+
+	// deploymentProgressDeadlineExceeded is the reason of the Progressing condition of a
+	// Deployment whose rollout did not progress within its progressDeadlineSeconds.
+	deploymentProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+	// rolloutCheckInterval is how often a followed rollout is checked on.
+	rolloutCheckInterval = time.Minute
+
+	rolloutResultSucceeded = "succeeded"
+	rolloutResultFailed    = "failed"
+)
+
+// restartsPaused returns why restarts are paused for owner, empty when they are not.
+func restartsPaused(owner client.Object) string {
+	if owner == nil {
+		return ""
+	}
+	return owner.GetAnnotations()[restartsPausedAnnotation]
+}
+
+// checkRollouts checks on the followed rollouts of the workloads using sa. A completed rollout
+// is no longer followed; a failed one is reported on the workload and on identity.Owner, whose
+// restarts are paused with PauseRestartsOnRolloutFailure. Rollouts still in progress are
+// checked again after rolloutCheckInterval.
+func (r *UserAssignedIdentityReconciler) checkRollouts(ctx context.Context, sa *corev1.ServiceAccount, identity workloadIdentity, result *serviceAccountSync, log logr.Logger) error {
+	var errs []error
+	for _, kind := range r.workloads() {
+		workloads, err := r.workloadsUsing(ctx, kind, sa.Name, sa.Namespace)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", kind.kind, err))
+			continue
+		}
+		for _, workload := range workloads {
+			if workload.GetAnnotations()[rolloutStartedAnnotation] == "" {
+				continue
+			}
+			ref := workloadRef{Kind: kind.kind, NamespacedName: client.ObjectKeyFromObject(workload)}
+			if failed, reason := kind.rolloutFailed(workload); failed {
+				log.Info("Rollout after client ID change failed", "workload", ref, "reason", reason)
+				r.event(workload, corev1.EventTypeWarning, "RolloutFailed",
+					fmt.Sprintf("Rollout after client ID change on ServiceAccount %s failed: %s", sa.Name, reason))
+				rolloutsTotal.WithLabelValues(kind.kind, rolloutResultFailed).Inc()
+				if err := r.rolloutFailed(ctx, identity.Owner, ref, reason); err != nil {
+					errs = append(errs, err)
+				}
+			} else if kind.rolledOut(workload) {
+				log.V(1).Info("Rollout after client ID change completed", "workload", ref)
+				r.event(workload, corev1.EventTypeNormal, "RolloutCompleted",
+					fmt.Sprintf("Rollout after client ID change on ServiceAccount %s completed", sa.Name))
+				rolloutsTotal.WithLabelValues(kind.kind, rolloutResultSucceeded).Inc()
+			} else {
+				result.requeue(rolloutCheckInterval)
+				continue
+			}
+			if err := r.setWorkloadAnnotation(ctx, workload, rolloutStartedAnnotation, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rolloutFailed reports the failed rollout of workload on owner and pauses its restarts when
+// configured to.
+func (r *UserAssignedIdentityReconciler) rolloutFailed(ctx context.Context, owner client.Object, workload workloadRef, reason string) error {
+	if owner == nil {
+		return nil
+	}
+	r.event(owner, corev1.EventTypeWarning, "RolloutFailed", fmt.Sprintf("Rollout of %s after client ID change failed: %s", workload, reason))
+	if !r.PauseRestartsOnRolloutFailure || restartsPaused(owner) != "" {
+		return nil
+	}
+
+	patch := client.MergeFrom(owner.DeepCopyObject().(client.Object))
+	annotations := owner.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[restartsPausedAnnotation] = fmt.Sprintf("rollout of %s failed", workload)
+	owner.SetAnnotations(annotations)
+	if err := r.Patch(ctx, owner, patch); err != nil {
+		return fmt.Errorf("failed to pause restarts: %w", err)
+	}
+	r.event(owner, corev1.EventTypeWarning, "RestartsPaused",
+		fmt.Sprintf("Workload restarts paused after the rollout of %s failed; remove the %s annotation to resume them", workload, restartsPausedAnnotation))
+	return nil
+}
+
+// setWorkloadAnnotation sets key to value in the metadata of workload, or removes it when
+// value is empty. Unlike a pod template annotation this does not roll out the workload.
+func (r *UserAssignedIdentityReconciler) setWorkloadAnnotation(ctx context.Context, workload client.Object, key, value string) error {
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	annotations := workload.GetAnnotations()
+	if value == "" {
+		delete(annotations, key)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	workload.SetAnnotations(annotations)
+	return r.Patch(ctx, workload, patch)
+}
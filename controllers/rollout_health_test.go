@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func progressDeadlineExceeded(deployment *appsv1.Deployment) {
+	deployment.Status.UpdatedReplicas = 0
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: deploymentProgressDeadlineExceeded,
+		Message: `ReplicaSet "first-abc" has timed out progressing.`, LastUpdateTime: metav1.Now(),
+	}}
+}
+
+func TestDeploymentRolloutFailed(t *testing.T) {
+	kind := workloadKinds[WorkloadKindDeployment]
+
+	deployment := rolledOutDeployment("first")
+	if failed, _ := kind.rolloutFailed(deployment); failed {
+		t.Error("Expected a rolled out Deployment not to have failed")
+	}
+	progressDeadlineExceeded(deployment)
+	if failed, reason := kind.rolloutFailed(deployment); !failed || !strings.Contains(reason, "timed out") {
+		t.Errorf("Expected ProgressDeadlineExceeded to fail the rollout, got %v %q", failed, reason)
+	}
+	// A deadline exceeded before the restart belongs to an earlier rollout
+	deployment.Annotations = map[string]string{rolloutStartedAnnotation: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+	if failed, _ := kind.rolloutFailed(deployment); failed {
+		t.Error("Expected a condition from before the restart to be ignored")
+	}
+	delete(deployment.Annotations, rolloutStartedAnnotation)
+	// The condition belongs to an older generation until the controller has observed the latest
+	deployment.Generation = 2
+	deployment.Status.ObservedGeneration = 1
+	if failed, _ := kind.rolloutFailed(deployment); failed {
+		t.Error("Expected a condition of an older generation to be ignored")
+	}
+}
+
+// followedDeployment marks name as restarted by the operator and lets mutate change its status.
+func followedDeployment(t *testing.T, cl client.Client, name string, mutate func(*appsv1.Deployment)) {
+	t.Helper()
+	var deployment appsv1.Deployment
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, &deployment); err != nil {
+		t.Fatalf("Failed to get Deployment: %v", err)
+	}
+	deployment.Annotations = map[string]string{rolloutStartedAnnotation: time.Now().UTC().Format(time.RFC3339)}
+	if err := cl.Update(context.Background(), &deployment); err != nil {
+		t.Fatalf("Failed to update Deployment: %v", err)
+	}
+	mutate(&deployment)
+	if err := cl.Status().Update(context.Background(), &deployment); err != nil {
+		t.Fatalf("Failed to update Deployment status: %v", err)
+	}
+}
+
+func followed(t *testing.T, cl client.Client, name string) bool {
+	t.Helper()
+	var deployment appsv1.Deployment
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, &deployment); err != nil {
+		t.Fatalf("Failed to get Deployment: %v", err)
+	}
+	return deployment.Annotations[rolloutStartedAnnotation] != ""
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return events
+}
+
+func TestCheckRollouts(t *testing.T) {
+	cl, r, recorder := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "test-client-id"})...)
+	followedDeployment(t, cl, "first", func(*appsv1.Deployment) {})
+	followedDeployment(t, cl, "second", func(d *appsv1.Deployment) { d.Status.AvailableReplicas = 0 })
+
+	result := syncServiceAccount(t, r, "test-client-id")
+	if followed(t, cl, "first") {
+		t.Error("Expected the completed rollout no longer to be followed")
+	}
+	if !followed(t, cl, "second") || result.RequeueAfter != rolloutCheckInterval {
+		t.Errorf("Expected the rollout in progress to be checked again in %v, got %v", rolloutCheckInterval, result.RequeueAfter)
+	}
+	events := strings.Join(drainEvents(recorder), "\n")
+	if !strings.Contains(events, "Normal RolloutCompleted") {
+		t.Errorf("Expected a RolloutCompleted event, got:\n%s", events)
+	}
+}
+
+func TestCheckRollouts_PauseOnFailure(t *testing.T) {
+	cl, r, recorder := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "old-client-id"})...)
+	r.PauseRestartsOnRolloutFailure = true
+	ctx := context.Background()
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	if err := cl.Create(ctx, identity); err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+	followedDeployment(t, cl, "first", progressDeadlineExceeded)
+
+	sync := func() {
+		key := types.NamespacedName{Name: restartTestSA, Namespace: "default"}
+		if _, err := r.updateServiceAccounts(ctx, []types.NamespacedName{key}, workloadIdentity{ClientID: "new-client-id", Owner: identity}, r.Log); err != nil {
+			t.Fatalf("updateServiceAccounts() error = %v", err)
+		}
+	}
+
+	sync()
+	var got mi.UserAssignedIdentity
+	_ = cl.Get(ctx, client.ObjectKeyFromObject(identity), &got)
+	if reason := got.Annotations[restartsPausedAnnotation]; !strings.Contains(reason, "Deployment default/first") {
+		t.Errorf("Expected restarts to be paused for the failed rollout, got %q", reason)
+	}
+	if followed(t, cl, "first") {
+		t.Error("Expected the failed rollout no longer to be followed")
+	}
+	if restarted(t, cl, "second") || pendingRestart(t, cl) == "" {
+		t.Error("Expected the restarts to be kept pending while paused")
+	}
+	events := strings.Join(drainEvents(recorder), "\n")
+	for _, want := range []string{"Warning RolloutFailed", "Warning RestartsPaused", "Warning RestartPaused"} {
+		if !strings.Contains(events, want) {
+			t.Errorf("Expected a %s event, got:\n%s", want, events)
+		}
+	}
+
+	// Clearing the annotation resumes the pending restarts
+	delete(identity.Annotations, restartsPausedAnnotation)
+	sync()
+	if !restarted(t, cl, "second") || pendingRestart(t, cl) != "" {
+		t.Error("Expected the pending restarts to resume once unpaused")
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ServiceAccountAppLabel links a ServiceAccount to an app regardless of its name.
+	ServiceAccountAppLabel = "clientid-operator/app"
+
+	// serviceAccountAppIndex indexes ServiceAccounts by the app they belong to.
+	serviceAccountAppIndex = "clientid-operator.app"
+
+	serviceAccountPrefix = "workload-identity-"
+)
+
+// serviceAccountApp is the field index function for serviceAccountAppIndex. The
+// clientid-operator/app label takes precedence over the `workload-identity-{appName}` name.
+func serviceAccountApp(obj client.Object) []string {
+	if app := obj.GetLabels()[ServiceAccountAppLabel]; app != "" {
+		return []string{app}
+	}
+	if app, ok := strings.CutPrefix(obj.GetName(), serviceAccountPrefix); ok && app != "" {
+		return []string{app}
+	}
+	return nil
+}
+
+// conventionServiceAccounts returns the keys of the ServiceAccounts in scope that belong to appName.
+func (r *UserAssignedIdentityReconciler) conventionServiceAccounts(ctx context.Context, appName string) ([]types.NamespacedName, error) {
+	var serviceAccounts corev1.ServiceAccountList
+	if err := r.List(ctx, &serviceAccounts, client.MatchingFields{serviceAccountAppIndex: appName}); err != nil {
+		return nil, err
+	}
+	keys := make([]types.NamespacedName, 0, len(serviceAccounts.Items))
+	for _, sa := range serviceAccounts.Items {
+		key := client.ObjectKeyFromObject(&sa)
+		inScope, err := r.inScope(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if inScope {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// setupServiceAccountIndex registers serviceAccountAppIndex.
+func setupServiceAccountIndex(mgr ctrl.Manager) error {
+	return mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.ServiceAccount{}, serviceAccountAppIndex, serviceAccountApp)
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestServiceAccountApp(t *testing.T) {
+	tests := []struct {
+		name   string
+		saName string
+		labels map[string]string
+		want   []string
+	}{
+		{name: "naming convention", saName: "workload-identity-testapp", want: []string{"testapp"}},
+		{name: "app name with dashes", saName: "workload-identity-order-api", want: []string{"order-api"}},
+		{name: "label", saName: "payments", labels: map[string]string{ServiceAccountAppLabel: "payments-api"}, want: []string{"payments-api"}},
+		{name: "label wins over name", saName: "workload-identity-testapp", labels: map[string]string{ServiceAccountAppLabel: "other"}, want: []string{"other"}},
+		{name: "prefix only", saName: "workload-identity-"},
+		{name: "unrelated", saName: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: tt.saName, Labels: tt.labels}}
+			if got := serviceAccountApp(sa); !slices.Equal(got, tt.want) {
+				t.Errorf("serviceAccountApp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConventionServiceAccounts(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+
+	objs := []client.Object{
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: "team-a"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "testapp-runner", Namespace: "team-b", Labels: map[string]string{ServiceAccountAppLabel: "testapp"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "workload-identity-testapp", Namespace: "team-c", Labels: map[string]string{ServiceAccountAppLabel: "other"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-otherapp", Namespace: "team-a"}},
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+
+	keys, err := r.conventionServiceAccounts(context.Background(), "testapp")
+	if err != nil {
+		t.Fatalf("conventionServiceAccounts() error = %v", err)
+	}
+	want := []types.NamespacedName{
+		{Name: "workload-identity-testapp", Namespace: "team-a"},
+		{Name: "testapp-runner", Namespace: "team-b"},
+	}
+	if !slices.Equal(keys, want) {
+		t.Errorf("conventionServiceAccounts() = %v, want %v", keys, want)
+	}
+}
+
+// BenchmarkServiceAccountDiscovery compares finding an app's ServiceAccounts with a Get in
+// every namespace against a single List on the app index, in a cluster where only a few
+// of the namespaces hold a ServiceAccount for the app. The fake client scans every object
+// on an indexed List where the cache would not, so calls/op is the more telling figure.
+func BenchmarkServiceAccountDiscovery(b *testing.B) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+
+	const namespaces = 5000
+	var objs []client.Object
+	for i := range namespaces {
+		ns := fmt.Sprintf("namespace-%d", i)
+		objs = append(objs,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}},
+			&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("workload-identity-app-%d", i), Namespace: ns}})
+		if i%1000 == 0 {
+			objs = append(objs, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "workload-identity-testapp", Namespace: ns}})
+		}
+	}
+	calls := 0
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				calls++
+				return c.Get(ctx, key, obj, opts...)
+			},
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				calls++
+				return c.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(false))}
+	ctx := context.Background()
+
+	b.Run("per-namespace Get", func(b *testing.B) {
+		calls = 0
+		for b.Loop() {
+			var list corev1.NamespaceList
+			if err := cl.List(ctx, &list); err != nil {
+				b.Fatal(err)
+			}
+			found := 0
+			for _, ns := range list.Items {
+				var sa corev1.ServiceAccount
+				err := cl.Get(ctx, types.NamespacedName{Name: "workload-identity-testapp", Namespace: ns.Name}, &sa)
+				if err == nil {
+					found++
+				} else if !errors.IsNotFound(err) {
+					b.Fatal(err)
+				}
+			}
+			if found != namespaces/1000 {
+				b.Fatalf("found %d ServiceAccounts", found)
+			}
+		}
+		b.ReportMetric(float64(calls)/float64(b.N), "calls/op")
+	})
+
+	b.Run("indexed List", func(b *testing.B) {
+		calls = 0
+		for b.Loop() {
+			keys, err := r.conventionServiceAccounts(ctx, "testapp")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(keys) != namespaces/1000 {
+				b.Fatalf("found %d ServiceAccounts", len(keys))
+			}
+		}
+		b.ReportMetric(float64(calls)/float64(b.N), "calls/op")
+	})
+}
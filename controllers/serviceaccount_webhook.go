@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+)
+
+// ServiceAccountWebhookPath is where the ServiceAccount mutating webhook is served.
+const ServiceAccountWebhookPath = "/mutate-serviceaccount"
+
+// ServiceAccountInjector is a mutating admission webhook that sets the workload identity
+// annotations on a ServiceAccount when it is created, looking up the identity of its app in
+// the cache. Pods then start with the right client ID on their first rollout, and workload
+// restarts are only needed when an identity is rotated. Updates are left to the reconciler,
+// as a client ID changed behind its back would leave running Pods on the old one.
+type ServiceAccountInjector struct {
+	Decoder admission.Decoder
+	// Identities provides the client, the namespace scope and the annotations to inject.
+	Identities *UserAssignedIdentityReconciler
+	// DryRun logs the annotations that would be injected without injecting them.
+	DryRun bool
+}
+
+// SetupWebhookWithManager registers the injector with the manager's webhook server.
+func (w *ServiceAccountInjector) SetupWebhookWithManager(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(ServiceAccountWebhookPath, &webhook.Admission{Handler: w})
+}
+
+// Handle injects the annotations of the ServiceAccount's identity on CREATE. Other operations
+// and ServiceAccounts without an app, out of scope or whose identity is ambiguous, bound or not
+// ready are admitted as they are.
+func (w *ServiceAccountInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
+	log := w.Identities.Log.WithValues("ServiceAccount", types.NamespacedName{Name: req.Name, Namespace: req.Namespace})
+
+	var sa corev1.ServiceAccount
+	if err := w.Decoder.Decode(req, &sa); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	apps := serviceAccountApp(&sa)
+	if len(apps) == 0 {
+		return admission.Allowed("")
+	}
+	inScope, err := w.Identities.inScope(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace})
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !inScope {
+		return admission.Allowed("")
+	}
+
+	identity, ok, err := w.identityForApp(ctx, apps[0])
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		log.V(1).Info("No single ready identity for app, not injecting", "app", apps[0])
+		return admission.Allowed("")
+	}
+
+	want := w.Identities.serviceAccountAnnotations(identity)
+	if len(changedAnnotations(&sa, want)) == 0 {
+		return admission.Allowed("")
+	}
+	if w.DryRun {
+		log.Info("Dry run: not injecting workload identity annotations", "annotations", want)
+		return admission.Allowed("")
+	}
+	if sa.Annotations == nil {
+		sa.Annotations = make(map[string]string)
+	}
+	maps.Copy(sa.Annotations, want)
+	raw, err := json.Marshal(&sa)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	log.Info("Injecting workload identity annotations", "clientID", identity.ClientID)
+	serviceAccountsInjectedTotal.Inc()
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
+
+// identityForApp returns the IDs of the identity of appName. It reports false unless there
+// is exactly one identity for the app, with a client ID, not being deleted and not bound
+// by an IdentityBinding, which leaves every other case to the reconcilers.
+func (w *ServiceAccountInjector) identityForApp(ctx context.Context, appName string) (workloadIdentity, bool, error) {
+	type candidate struct {
+		key      types.NamespacedName
+		deleting bool
+		clientID *string
+		tenantID *string
+	}
+	var candidates []candidate
+	var namespaced mi.UserAssignedIdentityList
+	if err := w.Identities.List(ctx, &namespaced, client.MatchingFields{identityAppIndex: appName}); err != nil {
+		return workloadIdentity{}, false, err
+	}
+	for _, identity := range namespaced.Items {
+		candidates = append(candidates, candidate{client.ObjectKeyFromObject(&identity), !identity.DeletionTimestamp.IsZero(),
+			identity.Status.AtProvider.ClientID, identity.Status.AtProvider.TenantID})
+	}
+	var cluster mi2.UserAssignedIdentityList
+	if err := w.Identities.List(ctx, &cluster, client.MatchingFields{identityAppIndex: appName}); err != nil {
+		return workloadIdentity{}, false, err
+	}
+	for _, identity := range cluster.Items {
+		candidates = append(candidates, candidate{client.ObjectKeyFromObject(&identity), !identity.DeletionTimestamp.IsZero(),
+			identity.Status.AtProvider.ClientID, identity.Status.AtProvider.TenantID})
+	}
+
+	if len(candidates) != 1 {
+		return workloadIdentity{}, false, nil
+	}
+	found := candidates[0]
+	if found.deleting || ptr.Deref(found.clientID, "") == "" {
+		return workloadIdentity{}, false, nil
+	}
+	if w.Identities.IdentityBindings {
+		bound, err := w.Identities.isBound(ctx, found.key)
+		if err != nil || bound {
+			return workloadIdentity{}, false, err
+		}
+	}
+	return workloadIdentity{ClientID: *found.clientID, TenantID: ptr.Deref(found.tenantID, "")}, true, nil
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestServiceAccountInjector(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = identityv1alpha1.AddToScheme(s)
+
+	ready := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	ready.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	ready.Status.AtProvider.TenantID = ptr.To("test-tenant-id")
+	pending := identityNamed("pending", "default", "id-service-pending-dv-azunea-001")
+	// Two identities claim the same app
+	first := identityNamed("first", "default", "id-service-shared-dv-azunea-001")
+	first.Status.AtProvider.ClientID = ptr.To("first-client-id")
+	second := identityNamed("second", "default", "id-service-shared-dv-azunea-002")
+	second.Status.AtProvider.ClientID = ptr.To("second-client-id")
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(ready, pending, first, second).Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), PropagateTenantID: true}
+
+	serviceAccountKind := metav1.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}
+	serviceAccount := func(name string, annotations map[string]string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations}}
+	}
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		obj       client.Object
+		dryRun    bool
+		patched   bool
+	}{
+		{name: "new ServiceAccount", obj: serviceAccount("workload-identity-testapp", nil), patched: true},
+		{name: "stale client ID", obj: serviceAccount("workload-identity-testapp", map[string]string{clientIDAnnotation: "old-client-id"}), patched: true},
+		{name: "current ServiceAccount", obj: serviceAccount("workload-identity-testapp",
+			map[string]string{clientIDAnnotation: "test-client-id", tenantIDAnnotation: "test-tenant-id"})},
+		// Updates are left to the reconciler, which restarts the workloads of a changed client ID
+		{name: "updated ServiceAccount", operation: admissionv1.Update, obj: serviceAccount("workload-identity-testapp", map[string]string{clientIDAnnotation: "old-client-id"})},
+		{name: "dry run", obj: serviceAccount("workload-identity-testapp", nil), dryRun: true},
+		{name: "identity without client ID", obj: serviceAccount("workload-identity-pending", nil)},
+		{name: "ambiguous identity", obj: serviceAccount("workload-identity-shared", nil)},
+		{name: "app without identity", obj: serviceAccount("workload-identity-missing", nil)},
+		{name: "unrelated ServiceAccount", obj: serviceAccount("default", nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation := tt.operation
+			if operation == "" {
+				operation = admissionv1.Create
+			}
+			w := &ServiceAccountInjector{Decoder: admission.NewDecoder(s), Identities: r, DryRun: tt.dryRun}
+			resp := w.Handle(context.Background(), admissionRequest(t, operation, serviceAccountKind, tt.obj))
+			if !resp.Allowed {
+				t.Fatalf("Expected the ServiceAccount to be allowed, got %v", resp.Result)
+			}
+			if got := len(resp.Patches) > 0; got != tt.patched {
+				t.Fatalf("Patches incorrect. Expected patched %v, got %v", tt.patched, resp.Patches)
+			}
+			if !tt.patched {
+				return
+			}
+			values := map[string]any{}
+			for _, patch := range resp.Patches {
+				if patch.Path == "/metadata/annotations" {
+					for key, value := range patch.Value.(map[string]any) {
+						values[key] = value
+					}
+					continue
+				}
+				values[patch.Path] = patch.Value
+			}
+			for key, want := range map[string]string{clientIDAnnotation: "test-client-id", tenantIDAnnotation: "test-tenant-id"} {
+				escaped := "/metadata/annotations/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+				if values[key] != want && values[escaped] != want {
+					t.Errorf("Annotation %s incorrect. Expected %s, got patches %v", key, want, resp.Patches)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// azureClientIDEnv is set on the containers of workload identity pods by the Azure
+	// Workload Identity webhook, from the client ID of their ServiceAccount at admission.
+	azureClientIDEnv = "AZURE_CLIENT_ID"
+
+	// podServiceAccountIndex indexes Pods by their ServiceAccount.
+	podServiceAccountIndex = "spec.serviceAccountName"
+)
+
+// workloadSet selects workloads by kind and key. A nil set selects every workload.
+type workloadSet map[workloadRef]bool
+
+func (s workloadSet) has(kind string, workload client.Object) bool {
+	return s == nil || s[workloadRef{Kind: kind, NamespacedName: client.ObjectKeyFromObject(workload)}]
+}
+
+// indexPodServiceAccount is the field index function for podServiceAccountIndex.
+func indexPodServiceAccount(obj client.Object) []string {
+	return []string{obj.(*corev1.Pod).Spec.ServiceAccountName}
+}
+
+// podClientID returns the AZURE_CLIENT_ID the containers of pod were started with.
+func podClientID(pod *corev1.Pod) (string, bool) {
+	for _, container := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		for _, env := range container.Env {
+			if env.Name == azureClientIDEnv && env.Value != "" {
+				return env.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// podStale reports whether pod runs with another client ID than clientID. A pod without
+// AZURE_CLIENT_ID is stale when it was admitted before the client ID changed at since, as it
+// got the client ID its ServiceAccount had then. A zero since only checks AZURE_CLIENT_ID.
+func podStale(pod *corev1.Pod, clientID string, since time.Time) bool {
+	if id, ok := podClientID(pod); ok {
+		return id != clientID
+	}
+	return !since.IsZero() && pod.CreationTimestamp.Time.Before(since)
+}
+
+// staleWorkloads finds the running pods of sa with a stale client ID, records their count
+// and returns the workloads owning them.
+func (r *UserAssignedIdentityReconciler) staleWorkloads(ctx context.Context, sa *corev1.ServiceAccount, since time.Time, log logr.Logger) (workloadSet, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(sa.Namespace), client.MatchingFields{podServiceAccountIndex: sa.Name}); err != nil {
+		return nil, err
+	}
+	clientID := sa.Annotations[clientIDAnnotation]
+	stale := workloadSet{}
+	count := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if !podStale(pod, clientID, since) {
+			continue
+		}
+		count++
+		workload, ok, err := r.podWorkload(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.V(1).Info("Pod with a stale client ID is not owned by a workload", "pod", pod.Name)
+			continue
+		}
+		stale[workload] = true
+	}
+	stalePodCounts.set(client.ObjectKeyFromObject(sa), count)
+	return stale, nil
+}
+
+// podWorkload returns the workload pod belongs to, following its ReplicaSet to the owning
+// Deployment or Rollout and its Job to the owning CronJob. It reports false for pods
+// without a controller.
+func (r *UserAssignedIdentityReconciler) podWorkload(ctx context.Context, pod *corev1.Pod) (workloadRef, bool, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return workloadRef{}, false, nil
+	}
+	if owner.Kind == "ReplicaSet" || owner.Kind == "Job" {
+		// Only the metadata is needed, so this is served by a metadata informer rather than
+		// one caching every ReplicaSet and Job in full
+		var parent metav1.PartialObjectMetadata
+		parent.SetGroupVersionKind(schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind))
+		if err := r.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: pod.Namespace}, &parent); err != nil {
+			if errors.IsNotFound(err) {
+				return workloadRef{}, false, nil
+			}
+			return workloadRef{}, false, err
+		}
+		// A bare ReplicaSet or Job is restarted by nothing the operator knows
+		if owner = metav1.GetControllerOf(&parent); owner == nil {
+			return workloadRef{}, false, nil
+		}
+	}
+	return workloadRef{Kind: owner.Kind, NamespacedName: types.NamespacedName{Name: owner.Name, Namespace: pod.Namespace}}, true, nil
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func podWithClientID(name, clientID string, created time.Time) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: restartTestSA,
+			Containers:         []corev1.Container{{Name: "app"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if clientID != "" {
+		pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: azureClientIDEnv, Value: clientID}}
+	}
+	return pod
+}
+
+func TestPodStale(t *testing.T) {
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		pod   *corev1.Pod
+		since time.Time
+		want  bool
+	}{
+		{name: "current env", pod: podWithClientID("a", "new-client-id", since.Add(-time.Hour)), since: since, want: false},
+		{name: "stale env", pod: podWithClientID("a", "old-client-id", since.Add(time.Hour)), since: since, want: true},
+		{name: "no env, admitted before the change", pod: podWithClientID("a", "", since.Add(-time.Hour)), since: since, want: true},
+		{name: "no env, admitted after the change", pod: podWithClientID("a", "", since.Add(time.Hour)), since: since, want: false},
+		{name: "no env, no change", pod: podWithClientID("a", "", since.Add(-time.Hour)), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := podStale(tt.pod, "new-client-id", tt.since); got != tt.want {
+				t.Errorf("podStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// ownedBy makes owner the controller of obj.
+func ownedBy(obj client.Object, owner client.Object, kind string) {
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: kind, Name: owner.GetName(), UID: types.UID(owner.GetName()), Controller: ptr.To(true),
+	}})
+}
+
+func TestRestartStalePodsOnly(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "old-client-id"})...)
+	r.RestartStalePodsOnly = true
+	ctx := context.Background()
+	key := types.NamespacedName{Name: restartTestSA, Namespace: "default"}
+	defer stalePodCounts.forget(key)
+
+	// first already runs the new client ID, second and a bare pod still run the old one
+	now := time.Now()
+	for _, name := range []string{"first", "second"} {
+		var deployment appsv1.Deployment
+		_ = cl.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, &deployment)
+		replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: name + "-abc", Namespace: "default"}}
+		ownedBy(replicaSet, &deployment, "Deployment")
+		clientID := "new-client-id"
+		if name == "second" {
+			clientID = "old-client-id"
+		}
+		pod := podWithClientID(name+"-abc-1", clientID, now)
+		ownedBy(pod, replicaSet, "ReplicaSet")
+		for _, obj := range []client.Object{replicaSet, pod} {
+			if err := cl.Create(ctx, obj); err != nil {
+				t.Fatalf("Failed to create %s: %v", obj.GetName(), err)
+			}
+		}
+	}
+	if err := cl.Create(ctx, podWithClientID("bare", "old-client-id", now)); err != nil {
+		t.Fatalf("Failed to create pod: %v", err)
+	}
+
+	result := syncServiceAccount(t, r, "new-client-id")
+	if restarted(t, cl, "first") {
+		t.Error("Expected the Deployment without stale pods not to be restarted")
+	}
+	if !restarted(t, cl, "second") {
+		t.Error("Expected the Deployment with a stale pod to be restarted")
+	}
+	if len(result.RestartedWorkloads) != 1 || pendingRestart(t, cl) != "" {
+		t.Errorf("Expected one restart and no pending restart, got %v", result.RestartedWorkloads)
+	}
+	if got := stalePodCounts.counts[key]; got != 2 {
+		t.Errorf("Expected 2 stale pods, got %d", got)
+	}
+
+	// Once second has rolled out only the bare pod is left
+	var pod corev1.Pod
+	_ = cl.Get(ctx, types.NamespacedName{Name: "second-abc-1", Namespace: "default"}, &pod)
+	_ = cl.Delete(ctx, &pod)
+	syncServiceAccount(t, r, "new-client-id")
+	if got := stalePodCounts.counts[key]; got != 1 {
+		t.Errorf("Expected 1 stale pod after the rollout, got %d", got)
+	}
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	lastSyncTimeAnnotation           = "clientid-operator/last-sync-time"
+	lastSyncResultAnnotation         = "clientid-operator/last-sync-result"
+	serviceAccountsPatchedAnnotation = "clientid-operator/service-accounts-patched"
+	roleAssignmentsUpdatedAnnotation = "clientid-operator/role-assignments-updated"
+	workloadsRestartedAnnotation     = "clientid-operator/workloads-restarted"
+	// missingTargetNamespacesAnnotation lists the target namespaces that did not exist at the
+	// last sync, so that they are only reported again once they change.
+	missingTargetNamespacesAnnotation = "clientid-operator/missing-target-namespaces"
+
+	syncResultSynced                     = "Synced"
+	syncResultMissingIDs                 = "MissingIDs"
+	syncResultInvalidName                = "InvalidName"
+	syncResultRoleAssignmentUpdateFailed = "RoleAssignmentUpdateFailed"
+)
+
+// workloadRef identifies a restarted workload.
+type workloadRef struct {
+	Kind string
+	types.NamespacedName
+}
+
+func (w workloadRef) String() string {
+	return w.Kind + " " + w.NamespacedName.String()
+}
+
+// serviceAccountSync lists the changes made by updateServiceAccounts.
+type serviceAccountSync struct {
+	// CreatedServiceAccounts were created in the identity's target namespaces.
+	CreatedServiceAccounts []types.NamespacedName
+	// MissingTargetNamespaces are the identity's target namespaces that do not exist.
+	MissingTargetNamespaces []string
+	// SyncedServiceAccounts are all existing, in-scope ServiceAccounts of the identity.
+	SyncedServiceAccounts []types.NamespacedName
+	// PatchedServiceAccounts got a new client ID annotation.
+	PatchedServiceAccounts []types.NamespacedName
+	// RestartedWorkloads were restarted to pick up a new client ID.
+	RestartedWorkloads []workloadRef
+	// PendingRestarts are ServiceAccounts whose workloads still await a restart.
+	PendingRestarts []types.NamespacedName
+	// RequeueAfter is when a pending restart needs another look, zero if none does.
+	RequeueAfter time.Duration
+}
+
+// requeue asks for another reconcile within d.
+func (s *serviceAccountSync) requeue(d time.Duration) {
+	if s.RequeueAfter == 0 || d < s.RequeueAfter {
+		s.RequeueAfter = d
+	}
+}
+
+// roleAssignmentSync lists the changes made by updateRoleAssignments.
+type roleAssignmentSync struct {
+	UpdatedRoleAssignments []string
+	FailedRoleAssignments  []string
+}
+
+// syncSummary collects the changes made while propagating one identity to its dependents.
+type syncSummary struct {
+	serviceAccountSync
+	roleAssignmentSync
+	federatedCredentialSync
+}
+
+func (s syncSummary) changed() bool {
+	return len(s.CreatedServiceAccounts) > 0 || len(s.PatchedServiceAccounts) > 0 || len(s.UpdatedRoleAssignments) > 0 ||
+		len(s.RestartedWorkloads) > 0 || len(s.UpdatedFederatedCredentials) > 0 || len(s.PrunedFederatedCredentials) > 0
+}
+
+func (s syncSummary) String() string {
+	msg := fmt.Sprintf("Patched %d ServiceAccount(s), updated %d RoleAssignment(s), restarted %d workload(s)",
+		len(s.PatchedServiceAccounts), len(s.UpdatedRoleAssignments), len(s.RestartedWorkloads))
+	if len(s.CreatedServiceAccounts) > 0 {
+		msg += fmt.Sprintf(", created %d ServiceAccount(s)", len(s.CreatedServiceAccounts))
+	}
+	if len(s.UpdatedFederatedCredentials) > 0 || len(s.PrunedFederatedCredentials) > 0 {
+		msg += fmt.Sprintf(", applied %d and pruned %d FederatedIdentityCredential(s)",
+			len(s.UpdatedFederatedCredentials), len(s.PrunedFederatedCredentials))
+	}
+	if len(s.PendingRestarts) > 0 {
+		msg += fmt.Sprintf(", %d ServiceAccount(s) with pending restarts", len(s.PendingRestarts))
+	}
+	return msg
+}
+
+// requeueResult shortens the requeue of result when a pending restart needs an earlier look.
+func (s syncSummary) requeueResult(result ctrl.Result) ctrl.Result {
+	if s.RequeueAfter > 0 && (result.RequeueAfter == 0 || s.RequeueAfter < result.RequeueAfter) {
+		result.RequeueAfter = s.RequeueAfter
+	}
+	return result
+}
+
+// event records an Event on obj when a recorder is configured.
+func (r *UserAssignedIdentityReconciler) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// recordSync reports the outcome of a completed sync on the identity.
+func (r *UserAssignedIdentityReconciler) recordSync(ctx context.Context, identity client.Object, summary syncSummary, log logr.Logger) {
+	result := syncResultSynced
+	if len(summary.FailedRoleAssignments) > 0 {
+		result = syncResultRoleAssignmentUpdateFailed
+		r.event(identity, corev1.EventTypeWarning, syncResultRoleAssignmentUpdateFailed,
+			fmt.Sprintf("Failed to update RoleAssignment(s) %s", strings.Join(summary.FailedRoleAssignments, ", ")))
+	}
+	if summary.changed() {
+		r.event(identity, corev1.EventTypeNormal, syncResultSynced, summary.String())
+	}
+	if missing := strings.Join(summary.MissingTargetNamespaces, ","); missing != "" && missing != identity.GetAnnotations()[missingTargetNamespacesAnnotation] {
+		r.event(identity, corev1.EventTypeWarning, "TargetNamespaceMissing",
+			fmt.Sprintf("Not creating ServiceAccounts in target namespace(s) %s: namespace does not exist", strings.Join(summary.MissingTargetNamespaces, ", ")))
+	}
+	r.recordSyncSummary(ctx, identity, result, summary, log)
+}
+
+// syncTimeRefreshInterval is the shortest interval at which last-sync-time is refreshed on an
+// identity that is in sync.
+const syncTimeRefreshInterval = time.Minute
+
+// recordSyncSummary writes the sync result and counts as annotations on the identity and
+// keeps the result for the introspection endpoint. Without changes the annotations are only
+// rewritten once last-sync-time is older than half the resync period, so every resync shows
+// that the identity is still reconciled while the resulting watch event does not keep the
+// identity in a reconcile loop.
+func (r *UserAssignedIdentityReconciler) recordSyncSummary(ctx context.Context, identity client.Object, result string, summary syncSummary, log logr.Logger) {
+	identityStates.setResult(client.ObjectKeyFromObject(identity), result, summary)
+	missing := strings.Join(summary.MissingTargetNamespaces, ",")
+	if !summary.changed() && identity.GetAnnotations()[lastSyncResultAnnotation] == result &&
+		identity.GetAnnotations()[missingTargetNamespacesAnnotation] == missing && !r.syncTimeStale(identity) {
+		return
+	}
+
+	patch := client.MergeFrom(identity.DeepCopyObject().(client.Object))
+	annotations := identity.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[lastSyncTimeAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	annotations[lastSyncResultAnnotation] = result
+	annotations[serviceAccountsPatchedAnnotation] = strconv.Itoa(len(summary.PatchedServiceAccounts))
+	annotations[roleAssignmentsUpdatedAnnotation] = strconv.Itoa(len(summary.UpdatedRoleAssignments))
+	annotations[workloadsRestartedAnnotation] = strconv.Itoa(len(summary.RestartedWorkloads))
+	if missing != "" {
+		annotations[missingTargetNamespacesAnnotation] = missing
+	} else {
+		delete(annotations, missingTargetNamespacesAnnotation)
+	}
+	identity.SetAnnotations(annotations)
+
+	if err := r.Patch(ctx, identity, patch); err != nil {
+		log.Error(err, "Failed to record sync summary on UserAssignedIdentity")
+	}
+}
+
+// syncTimeStale reports whether last-sync-time on identity is missing or due for a refresh.
+func (r *UserAssignedIdentityReconciler) syncTimeStale(identity client.Object) bool {
+	last, err := time.Parse(time.RFC3339, identity.GetAnnotations()[lastSyncTimeAnnotation])
+	if err != nil {
+		return true
+	}
+	return time.Since(last) >= max(r.ResyncPeriod/2, syncTimeRefreshInterval)
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestRecordSyncSummary_RefreshesSyncTime(t *testing.T) {
+	s := scheme.Scheme
+	_ = mi.AddToScheme(s)
+	tests := []struct {
+		name     string
+		lastSync time.Time
+		want     bool
+	}{
+		{name: "recent sync", lastSync: time.Now().Add(-time.Minute), want: false},
+		{name: "sync older than half the resync period", lastSync: time.Now().Add(-6 * time.Minute), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+			lastSync := tt.lastSync.UTC().Format(time.RFC3339)
+			identity.Annotations = map[string]string{lastSyncTimeAnnotation: lastSync, lastSyncResultAnnotation: syncResultSynced}
+			cl := fake.NewClientBuilder().WithScheme(s).WithObjects(identity).Build()
+			r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), ResyncPeriod: 10 * time.Minute}
+			defer identityStates.forget(client.ObjectKeyFromObject(identity))
+
+			r.recordSyncSummary(context.Background(), identity, syncResultSynced, syncSummary{}, r.Log)
+			var got mi.UserAssignedIdentity
+			if err := cl.Get(context.Background(), client.ObjectKeyFromObject(identity), &got); err != nil {
+				t.Fatalf("Failed to get identity: %v", err)
+			}
+			if refreshed := got.Annotations[lastSyncTimeAnnotation] != lastSync; refreshed != tt.want {
+				t.Errorf("Expected last-sync-time refreshed = %v, got %s", tt.want, got.Annotations[lastSyncTimeAnnotation])
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// targetNamespacesAnnotation on a UserAssignedIdentity lists the namespaces in which the
+	// operator creates the `workload-identity-{appName}` ServiceAccount when it is missing.
+	targetNamespacesAnnotation = "clientid-operator/target-namespaces"
+	// identityAnnotation on a created ServiceAccount names the identity it was created for.
+	identityAnnotation = "clientid-operator/identity"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+
+	// missingNamespaceRecheck is how often an identity is reconciled while one of its target
+	// namespaces does not exist, so its ServiceAccount is created soon after the namespace is.
+	missingNamespaceRecheck = 1 * time.Minute
+)
+
+// targetNamespaces returns the namespaces listed in the target-namespaces annotation of identity.
+func targetNamespaces(identity client.Object) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(identity.GetAnnotations()[targetNamespacesAnnotation], ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace != "" && !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// createServiceAccounts creates the ServiceAccount of appName in the target namespaces of
+// identity that have none of the app's ServiceAccounts among existing, and returns their keys
+// and the target namespaces that do not exist yet. The ServiceAccounts are labelled with the
+// app and owned by identity where Kubernetes allows it, that is when identity is
+// cluster-scoped or in the same namespace. Their workload identity annotations are left to
+// updateServiceAccounts.
+func (r *UserAssignedIdentityReconciler) createServiceAccounts(ctx context.Context, identity client.Object, appName string, existing []types.NamespacedName, log logr.Logger) ([]types.NamespacedName, []string, error) {
+	var created []types.NamespacedName
+	var missing []string
+	for _, namespace := range targetNamespaces(identity) {
+		if slices.ContainsFunc(existing, func(key types.NamespacedName) bool { return key.Namespace == namespace }) {
+			continue
+		}
+		key := types.NamespacedName{Name: serviceAccountPrefix + appName, Namespace: namespace}
+		inScope, err := r.inScope(ctx, key)
+		if err != nil {
+			return created, missing, err
+		}
+		if !inScope {
+			r.event(identity, corev1.EventTypeWarning, "TargetNamespaceOutOfScope",
+				fmt.Sprintf("Not creating ServiceAccount %s: namespace is outside the watched namespaces", key))
+			continue
+		}
+
+		identityRef := identity.GetName()
+		if identity.GetNamespace() != "" {
+			identityRef = identity.GetNamespace() + "/" + identityRef
+		}
+		sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Labels:      map[string]string{ServiceAccountAppLabel: appName, managedByLabel: FieldManager},
+			Annotations: map[string]string{identityAnnotation: identityRef},
+		}}
+		if identity.GetNamespace() == "" || identity.GetNamespace() == namespace {
+			if err := controllerutil.SetOwnerReference(identity, sa, r.Scheme); err != nil {
+				return created, missing, err
+			}
+		}
+		if err := r.Create(ctx, sa, client.FieldOwner(FieldManager)); err != nil {
+			if errors.IsAlreadyExists(err) {
+				// Either created earlier and not in the cache yet, which the ServiceAccount
+				// watch catches up on, or claimed by another app through its label
+				continue
+			}
+			if errors.IsNotFound(err) {
+				// Reported once per change by recordSync
+				log.V(1).Info("Target namespace does not exist, skipping", "ServiceAccount", key)
+				missing = append(missing, namespace)
+				continue
+			}
+			log.Error(err, "Failed to create ServiceAccount", "ServiceAccount", key)
+			r.event(identity, corev1.EventTypeWarning, "ServiceAccountCreateFailed", fmt.Sprintf("Failed to create ServiceAccount %s: %v", key, err))
+			continue
+		}
+		log.Info("Created ServiceAccount", "ServiceAccount", key)
+		r.event(identity, corev1.EventTypeNormal, "ServiceAccountCreated", fmt.Sprintf("Created ServiceAccount %s", key))
+		created = append(created, key)
+	}
+	return created, missing, nil
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
+	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestTargetNamespaces(t *testing.T) {
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Annotations = map[string]string{targetNamespacesAnnotation: " team-a, team-b,,team-a "}
+	if got, want := targetNamespaces(identity), []string{"team-a", "team-b"}; !slices.Equal(got, want) {
+		t.Errorf("targetNamespaces() = %v, want %v", got, want)
+	}
+}
+
+func TestCreateServiceAccounts(t *testing.T) {
+	s := scheme.Scheme
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Annotations = map[string]string{targetNamespacesAnnotation: "default,team-a,team-b,kube-system"}
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	objs := []client.Object{
+		identity,
+		// team-b already has a ServiceAccount for the app under another name
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name: "runner", Namespace: "team-b", Labels: map[string]string{ServiceAccountAppLabel: "testapp"},
+		}},
+	}
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+	r := &UserAssignedIdentityReconciler{
+		Client:        cl,
+		Scheme:        s,
+		Log:           zap.New(zap.UseDevMode(true)),
+		Recorder:      record.NewFakeRecorder(20),
+		Namespaces:    NamespaceScope{Exclude: []string{"kube-system"}},
+		WorkloadKinds: []string{},
+	}
+	ctx := context.Background()
+
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	outOfSyncIdentities.forget(key)
+
+	var serviceAccounts corev1.ServiceAccountList
+	if err := cl.List(ctx, &serviceAccounts); err != nil {
+		t.Fatalf("Failed to list ServiceAccounts: %v", err)
+	}
+	var got []string
+	for _, sa := range serviceAccounts.Items {
+		got = append(got, sa.Namespace+"/"+sa.Name)
+		if sa.Annotations[clientIDAnnotation] != "test-client-id" {
+			t.Errorf("ServiceAccount %s/%s not annotated, got %v", sa.Namespace, sa.Name, sa.Annotations)
+		}
+		if sa.Name == "runner" {
+			continue
+		}
+		if sa.Labels[ServiceAccountAppLabel] != "testapp" || sa.Annotations[identityAnnotation] != "default/testapp" {
+			t.Errorf("ServiceAccount %s/%s not linked to its identity: %v %v", sa.Namespace, sa.Name, sa.Labels, sa.Annotations)
+		}
+		// Owner references cannot cross namespaces
+		if owned := len(sa.OwnerReferences) > 0; owned != (sa.Namespace == "default") {
+			t.Errorf("ServiceAccount %s/%s owner references incorrect: %v", sa.Namespace, sa.Name, sa.OwnerReferences)
+		}
+	}
+	slices.Sort(got)
+	want := []string{"default/workload-identity-testapp", "team-a/workload-identity-testapp", "team-b/runner"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ServiceAccounts incorrect. Expected %v, got %v", want, got)
+	}
+
+	var current mi.UserAssignedIdentity
+	_ = cl.Get(ctx, key, &current)
+	if got := current.Annotations[lastSyncResultAnnotation]; got != syncResultSynced {
+		t.Errorf("Sync result incorrect. Expected %s, got %s", syncResultSynced, got)
+	}
+}
+
+func TestCreateServiceAccounts_MissingNamespace(t *testing.T) {
+	identity := identityNamed("testapp", "default", "id-service-testapp-dv-azunea-001")
+	identity.Annotations = map[string]string{targetNamespacesAnnotation: "team-a"}
+	identity.Status.AtProvider.ClientID = ptr.To("test-client-id")
+	identity.Status.AtProvider.PrincipalID = ptr.To("test-principal-id")
+	cl, r, recorder := newTestReconciler(t, identity)
+	r.WorkloadKinds = []string{}
+	r.ResyncPeriod = time.Hour
+	namespaceExists := false
+	r.Client = interceptor.NewClient(cl.(client.WithWatch), interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if !namespaceExists {
+				return errors.NewNotFound(corev1.Resource("namespaces"), obj.GetNamespace())
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	})
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "testapp", Namespace: "default"}
+	defer outOfSyncIdentities.forget(key)
+
+	// Reported once, however often the identity is reconciled while the namespace is missing
+	for range 3 {
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		if err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+		if result.RequeueAfter != missingNamespaceRecheck {
+			t.Errorf("Expected a recheck for the namespace within %v, got %v", missingNamespaceRecheck, result.RequeueAfter)
+		}
+	}
+	var missing []string
+	for _, event := range drainEvents(recorder) {
+		if strings.Contains(event, "TargetNamespaceMissing") {
+			missing = append(missing, event)
+		}
+	}
+	if len(missing) != 1 {
+		t.Errorf("Expected 1 TargetNamespaceMissing event, got %v", missing)
+	}
+	var current mi.UserAssignedIdentity
+	_ = cl.Get(ctx, key, &current)
+	if got := current.Annotations[missingTargetNamespacesAnnotation]; got != "team-a" {
+		t.Errorf("Expected team-a to be recorded as missing, got %q", got)
+	}
+
+	namespaceExists = true
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	var sa corev1.ServiceAccount
+	if err := cl.Get(ctx, types.NamespacedName{Name: "workload-identity-testapp", Namespace: "team-a"}, &sa); err != nil {
+		t.Errorf("Expected the ServiceAccount to be created once the namespace exists: %v", err)
+	}
+	_ = cl.Get(ctx, key, &current)
+	if got, ok := current.Annotations[missingTargetNamespacesAnnotation]; ok {
+		t.Errorf("Expected no missing namespaces, got %q", got)
+	}
+}
@@ -3,16 +3,25 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
 	"github.com/go-logr/logr"
 	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
 	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
@@ -24,245 +33,422 @@ type UserAssignedIdentityReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Log    logr.Logger
+
+	// AppNames derives the app name from an identity. Defaults to the third
+	// dash-separated segment of spec.forProvider.name.
+	AppNames AppNameExtractor
+
+	// IdentityBindings makes the convention-based reconcile skip identities that
+	// are referenced by an IdentityBinding, leaving them to the binding controller.
+	IdentityBindings bool
+
+	// Recorder emits Events on the identities, ServiceAccounts and workloads the operator touches.
+	Recorder record.EventRecorder
+
+	// WorkloadKinds are the workload kinds restarted after a client ID change.
+	// Defaults to DefaultWorkloadKinds.
+	WorkloadKinds []string
+
+	// RestartPolicy decides when workloads are restarted after a client ID change, unless a
+	// ServiceAccount sets its own with the clientid-operator/restart-policy annotation.
+	// Defaults to RestartPolicyImmediate.
+	RestartPolicy string
+
+	// MaintenanceWindow is when the maintenance-window restart policy restarts workloads.
+	MaintenanceWindow *MaintenanceWindow
+
+	// RestartStrategy is how workloads are restarted, unless a workload sets its own with the
+	// clientid-operator/restart-strategy annotation. Defaults to RestartStrategyTemplateAnnotation.
+	RestartStrategy string
+
+	// PauseRestartsOnRolloutFailure pauses the restarts of an identity's workloads when a
+	// rollout it triggered fails, until the clientid-operator/restarts-paused annotation is
+	// removed from the identity.
+	PauseRestartsOnRolloutFailure bool
+
+	// RestartStalePodsOnly restarts only the workloads with running pods whose AZURE_CLIENT_ID
+	// differs from the ServiceAccount's client ID, instead of every workload using it.
+	RestartStalePodsOnly bool
+
+	// Namespaces restricts the namespaces searched for ServiceAccounts.
+	Namespaces NamespaceScope
+
+	// ResyncPeriod is how long to wait before reconciling an identity that is in sync
+	// again. Changes to ServiceAccounts and RoleAssignments are picked up by watches, so
+	// this only catches missed events. Zero disables the periodic resync.
+	ResyncPeriod time.Duration
+
+	// CleanupPolicy is what happens to the dependents of a deleted identity without a
+	// clientid-operator/cleanup-policy annotation of its own. Empty means orphan.
+	CleanupPolicy string
+
+	// PropagateTenantID also propagates the identity's tenant ID to the azure.workload.identity/tenant-id annotation.
+	PropagateTenantID bool
+	// TokenExpiration sets azure.workload.identity/service-account-token-expiration when non-zero.
+	TokenExpiration time.Duration
+	// UseLabel adds the azure.workload.identity/use label to the pod templates of the workloads
+	// using the ServiceAccounts.
+	UseLabel bool
+	// RoleAssignmentScope is which RoleAssignments of its app an identity is wired to:
+	// identity (its own scope and namespace) or cluster (both scopes, all namespaces).
+	// Empty means identity.
+	RoleAssignmentScope string
+	// OIDCIssuerURL is the OIDC issuer of the cluster. When set, every identity gets a
+	// FederatedIdentityCredential trusting each of its ServiceAccounts.
+	OIDCIssuerURL string
+	// DryRun is set when Client skips writes. The cleanup finalizer is then never added, and
+	// changes are not rechecked before the next resync since they were not made.
+	DryRun bool
 }
 
 func (r *UserAssignedIdentityReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("userassignedidentity", req.NamespacedName)
 
-	// Try namespaced UserAssignedIdentity first
+	// Cluster-scoped identities are enqueued without a namespace, which keeps
+	// their requests distinct from namespaced identities sharing the same name.
+	if req.Namespace == "" {
+		var clusterIdentity mi2.UserAssignedIdentity
+		if err := r.Get(ctx, req.NamespacedName, &clusterIdentity); err != nil {
+			if errors.IsNotFound(err) {
+				outOfSyncIdentities.forget(req.NamespacedName)
+				identityStates.forget(req.NamespacedName)
+				return ctrl.Result{}, nil
+			}
+			log.Error(err, "Error fetching cluster-scoped UserAssignedIdentity")
+			return ctrl.Result{}, err
+		}
+		result, err := r.reconcileClusterIdentity(ctx, &clusterIdentity, log)
+		recordReconcile(&clusterIdentity, err)
+		return result, err
+	}
+
 	var identity mi.UserAssignedIdentity
-	err := r.Get(ctx, req.NamespacedName, &identity)
-	if err != nil {
+	if err := r.Get(ctx, req.NamespacedName, &identity); err != nil {
 		if errors.IsNotFound(err) {
-			// Try cluster-scoped UserAssignedIdentity
-			var clusterIdentity mi2.UserAssignedIdentity
-			if err2 := r.Get(ctx, req.NamespacedName, &clusterIdentity); err2 != nil {
-				log.Error(err2, "Unable to fetch UserAssignedIdentity from either namespaced or cluster scope")
-				return ctrl.Result{}, client.IgnoreNotFound(err2)
-			}
-			// Use cluster-scoped identity
-			return r.reconcileClusterIdentity(ctx, &clusterIdentity, log)
+			outOfSyncIdentities.forget(req.NamespacedName)
+			identityStates.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
 		}
 		log.Error(err, "Error fetching namespaced UserAssignedIdentity")
 		return ctrl.Result{}, err
 	}
 
 	// Use namespaced identity
-	return r.reconcileNamespacedIdentity(ctx, &identity, log)
+	result, err := r.reconcileNamespacedIdentity(ctx, &identity, log)
+	recordReconcile(&identity, err)
+	return result, err
+}
+
+// recordReconcile records the outcome of a reconcile of identity for the introspection
+// endpoint. An identity released for deletion is forgotten, as it may be gone before it is
+// reconciled again.
+func recordReconcile(identity client.Object, err error) {
+	key := client.ObjectKeyFromObject(identity)
+	if err == nil && !identity.GetDeletionTimestamp().IsZero() {
+		identityStates.forget(key)
+		return
+	}
+	identityStates.reconciled(key, err)
 }
 
 func (r *UserAssignedIdentityReconciler) reconcileNamespacedIdentity(ctx context.Context, identity *mi.UserAssignedIdentity, log logr.Logger) (ctrl.Result, error) {
-	clientID := identity.Status.AtProvider.ClientID
-	principalID := identity.Status.AtProvider.PrincipalID
-	appName := extractAppName(*identity.Spec.ForProvider.Name)
+	return r.reconcileIdentity(ctx, identity, "namespaced", identity.Status.AtProvider.ClientID, identity.Status.AtProvider.PrincipalID, identity.Status.AtProvider.TenantID, log)
+}
 
-	log.Info("Fetched namespaced UserAssignedIdentity", "clientID", clientID, "principalID", principalID, "appName", appName)
+func (r *UserAssignedIdentityReconciler) reconcileClusterIdentity(ctx context.Context, identity *mi2.UserAssignedIdentity, log logr.Logger) (ctrl.Result, error) {
+	return r.reconcileIdentity(ctx, identity, "cluster-scoped", identity.Status.AtProvider.ClientID, identity.Status.AtProvider.PrincipalID, identity.Status.AtProvider.TenantID, log)
+}
 
-	if clientID == nil || *clientID == "" || principalID == nil || *principalID == "" {
-		log.Info("Missing critical ID information, skipping update.")
-		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
-	}
+// reconcileIdentity propagates the IDs of an identity of either scope to its dependents by naming convention.
+func (r *UserAssignedIdentityReconciler) reconcileIdentity(ctx context.Context, identity client.Object, scope string, clientID, principalID, tenantID *string, log logr.Logger) (ctrl.Result, error) {
+	key := client.ObjectKeyFromObject(identity)
 
-	if appName == "" {
-		log.Error(fmt.Errorf("invalid name format"), "Cannot extract appName", "name", *identity.Spec.ForProvider.Name)
-		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
+	bound := false
+	if r.IdentityBindings {
+		var err error
+		if bound, err = r.isBound(ctx, key); err != nil {
+			log.Error(err, "Error listing IdentityBindings")
+			return ctrl.Result{}, err
+		}
 	}
-
-	updateNeeded, err := r.updateServiceAccounts(ctx, appName, *clientID, log)
-	if err != nil {
-		log.Error(err, "Failed to update ServiceAccounts")
-		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
+	if !identity.GetDeletionTimestamp().IsZero() {
+		if bound {
+			// The dependents of a bound identity belong to its IdentityBinding
+			outOfSyncIdentities.forget(key)
+			return ctrl.Result{}, r.setCleanupFinalizer(ctx, identity, false)
+		}
+		return r.finalizeIdentity(ctx, identity, clientID, principalID, log)
 	}
-
-	roleUpdateNeeded, err := r.updateRoleAssignments(ctx, appName, *principalID, log)
-	if err != nil {
-		log.Error(err, "Failed to update RoleAssignments")
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, err
+	// A dry run never adds the finalizer, since it could not make the cleanup it stands for
+	if err := r.setCleanupFinalizer(ctx, identity, !r.DryRun && !bound && r.cleanupPolicy(identity) != CleanupPolicyOrphan); err != nil {
+		log.Error(err, "Failed to update cleanup finalizer")
+		return ctrl.Result{}, err
 	}
-
-	if updateNeeded || roleUpdateNeeded {
-		log.Info("Updates applied, rechecking in 60 seconds to ensure state.")
-		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+	if bound {
+		log.V(1).Info("UserAssignedIdentity is managed by an IdentityBinding, skipping naming convention")
+		if err := r.handOverToBindings(ctx, identity, clientID, principalID, log); err != nil {
+			log.Error(err, "Failed to hand UserAssignedIdentity over to its IdentityBindings")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
 	}
 
-	return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
-}
-
-func (r *UserAssignedIdentityReconciler) reconcileClusterIdentity(ctx context.Context, identity *mi2.UserAssignedIdentity, log logr.Logger) (ctrl.Result, error) {
-	clientID := identity.Status.AtProvider.ClientID
-	principalID := identity.Status.AtProvider.PrincipalID
-	appName := extractAppName(*identity.Spec.ForProvider.Name)
+	appName, nameErr := r.extractAppName(identity)
 
-	log.Info("Fetched cluster-scoped UserAssignedIdentity", "clientID", clientID, "principalID", principalID, "appName", appName)
+	log.Info(fmt.Sprintf("Fetched %s UserAssignedIdentity", scope), "clientID", clientID, "principalID", principalID, "appName", appName)
 
 	if clientID == nil || *clientID == "" || principalID == nil || *principalID == "" {
 		log.Info("Missing critical ID information, skipping update.")
+		r.event(identity, corev1.EventTypeWarning, syncResultMissingIDs, "UserAssignedIdentity has no client or principal ID yet, skipping update")
+		r.recordSyncSummary(ctx, identity, syncResultMissingIDs, syncSummary{}, log)
+		identitiesSkippedTotal.WithLabelValues(skipReasonMissingIDs).Inc()
+		outOfSyncIdentities.set(key, true)
 		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
 	}
 
-	if appName == "" {
-		log.Error(fmt.Errorf("invalid name format"), "Cannot extract appName", "name", *identity.Spec.ForProvider.Name)
+	if nameErr != nil {
+		log.Error(nameErr, "Cannot extract appName")
+		r.event(identity, corev1.EventTypeWarning, syncResultInvalidName, fmt.Sprintf("Cannot extract app name: %v", nameErr))
+		r.recordSyncSummary(ctx, identity, syncResultInvalidName, syncSummary{}, log)
+		identitiesSkippedTotal.WithLabelValues(skipReasonInvalidName).Inc()
+		outOfSyncIdentities.set(key, true)
 		return ctrl.Result{RequeueAfter: time.Minute * 5}, nil
 	}
 
-	updateNeeded, err := r.updateServiceAccounts(ctx, appName, *clientID, log)
+	// Identities sharing an app name overwrite each other's ServiceAccounts, and their
+	// RoleAssignments too when they share a RoleAssignment scope
+	conflicts, err := r.conflictingIdentities(ctx, identity, appName)
+	if err != nil {
+		log.Error(err, "Failed to check for app name conflicts")
+		return ctrl.Result{}, err
+	}
+	if len(conflicts) > 0 {
+		log.Info("App name is shared with other UserAssignedIdentities", "conflicts", conflicts)
+		r.event(identity, corev1.EventTypeWarning, "AppNameConflict",
+			fmt.Sprintf("App name %s is also used by UserAssignedIdentity %s; they overwrite each other's ServiceAccounts", appName, strings.Join(conflicts, ", ")))
+		appNameConflictsTotal.Inc()
+	}
+
+	serviceAccounts, err := r.conventionServiceAccounts(ctx, appName)
+	if err != nil {
+		log.Error(err, "Failed to list ServiceAccounts")
+		outOfSyncIdentities.set(key, true)
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
+	}
+
+	created, missingNamespaces, err := r.createServiceAccounts(ctx, identity, appName, serviceAccounts, log)
+	if err != nil {
+		log.Error(err, "Failed to create ServiceAccounts")
+		outOfSyncIdentities.set(key, true)
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
+	}
+	serviceAccounts = append(serviceAccounts, created...)
+
+	serviceAccountSync, err := r.updateServiceAccounts(ctx, serviceAccounts, workloadIdentity{ClientID: *clientID, TenantID: ptr.Deref(tenantID, ""), Owner: identity}, log)
 	if err != nil {
 		log.Error(err, "Failed to update ServiceAccounts")
+		r.event(identity, corev1.EventTypeWarning, "ServiceAccountUpdateFailed", fmt.Sprintf("Failed to update ServiceAccounts: %v", err))
+		outOfSyncIdentities.set(key, true)
 		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
 	}
+	serviceAccountSync.CreatedServiceAccounts = created
+	serviceAccountSync.MissingTargetNamespaces = missingNamespaces
+	if len(missingNamespaces) > 0 {
+		serviceAccountSync.requeue(missingNamespaceRecheck)
+	}
 
-	roleUpdateNeeded, err := r.updateRoleAssignments(ctx, appName, *principalID, log)
+	roleAssignmentSync, err := r.updateRoleAssignments(ctx, conventionRoleAssignmentSelector(appName), r.roleAssignmentScopeFor(identity), *principalID, log)
 	if err != nil {
 		log.Error(err, "Failed to update RoleAssignments")
+		r.event(identity, corev1.EventTypeWarning, "RoleAssignmentUpdateFailed", fmt.Sprintf("Failed to update RoleAssignments: %v", err))
+		outOfSyncIdentities.set(key, true)
 		return ctrl.Result{RequeueAfter: 5 * time.Minute}, err
 	}
 
-	if updateNeeded || roleUpdateNeeded {
-		log.Info("Updates applied, rechecking in 60 seconds to ensure state.")
-		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+	federatedCredentialSync, err := r.syncFederatedCredentials(ctx, identity, serviceAccountSync.SyncedServiceAccounts, log)
+	if err != nil {
+		log.Error(err, "Failed to sync FederatedIdentityCredentials")
+		r.event(identity, corev1.EventTypeWarning, "FederatedCredentialSyncFailed", fmt.Sprintf("Failed to sync FederatedIdentityCredentials: %v", err))
+		outOfSyncIdentities.set(key, true)
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, err
 	}
 
-	return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+	summary := syncSummary{serviceAccountSync, roleAssignmentSync, federatedCredentialSync}
+	r.recordSync(ctx, identity, summary, log)
+	outOfSyncIdentities.set(key, len(summary.FailedRoleAssignments) > 0)
+
+	result := ctrl.Result{RequeueAfter: r.ResyncPeriod}
+	if len(summary.CreatedServiceAccounts) > 0 || len(summary.PatchedServiceAccounts) > 0 || len(summary.UpdatedRoleAssignments) > 0 ||
+		len(summary.UpdatedFederatedCredentials) > 0 {
+		result = r.recheck(log)
+	}
+	return summary.requeueResult(result), nil
 }
 
-func (r *UserAssignedIdentityReconciler) updateServiceAccounts(ctx context.Context, appName, clientID string, log logr.Logger) (bool, error) {
-	var namespaces corev1.NamespaceList
-	if err := r.List(ctx, &namespaces); err != nil {
-		return false, err
+// recheck returns the result of a reconcile that changed something. Changes are rechecked
+// after a minute, except in dry run, where they were only logged and would be again.
+func (r *UserAssignedIdentityReconciler) recheck(log logr.Logger) ctrl.Result {
+	if r.DryRun {
+		return ctrl.Result{RequeueAfter: r.ResyncPeriod}
 	}
-	updateNeeded := false
-	for _, ns := range namespaces.Items {
-		saName := fmt.Sprintf("workload-identity-%s", appName)
+	log.Info("Updates applied, rechecking in 60 seconds to ensure state.")
+	return ctrl.Result{RequeueAfter: 1 * time.Minute}
+}
+
+// conventionRoleAssignmentSelector selects the RoleAssignments labelled for appName.
+func conventionRoleAssignmentSelector(appName string) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{"application": appName, "type": "roleassignment"})
+}
+
+// updateServiceAccounts annotates the given ServiceAccounts with identity, skipping any
+// that do not exist or are out of scope, and restarts the workloads using the ServiceAccounts it changed.
+func (r *UserAssignedIdentityReconciler) updateServiceAccounts(ctx context.Context, serviceAccounts []types.NamespacedName, identity workloadIdentity, log logr.Logger) (serviceAccountSync, error) {
+	want := r.serviceAccountAnnotations(identity)
+	var result serviceAccountSync
+	for _, key := range serviceAccounts {
+		inScope, err := r.inScope(ctx, key)
+		if err != nil {
+			return result, err
+		}
+		if !inScope {
+			log.V(1).Info("ServiceAccount is outside the watched namespaces, skipping", "ServiceAccount", key)
+			continue
+		}
+
 		var sa corev1.ServiceAccount
-		if err := r.Get(ctx, client.ObjectKey{Name: saName, Namespace: ns.Name}, &sa); err != nil {
+		if err := r.Get(ctx, key, &sa); err != nil {
 			if errors.IsNotFound(err) {
+				stalePodCounts.forget(key)
 				continue
 			}
-			return false, err
+			return result, err
 		}
-
-		if sa.Annotations == nil || sa.Annotations["azure.workload.identity/client-id"] != clientID {
+		result.SyncedServiceAccounts = append(result.SyncedServiceAccounts, key)
+
+		// Restarts are decided per ServiceAccount, so workloads using an already
+		// current ServiceAccount are left alone. Only a new client ID restarts them; the
+		// other annotations are picked up by the next rollout.
+		changed := false
+		if keys := changedAnnotations(&sa, want); len(keys) > 0 {
+			if err := r.applyServiceAccountAnnotations(ctx, &sa, want); err != nil {
+				return result, err
+			}
 			if sa.Annotations == nil {
 				sa.Annotations = make(map[string]string)
 			}
-			sa.Annotations["azure.workload.identity/client-id"] = clientID
-			if err := r.Update(ctx, &sa); err != nil {
-				return false, err
+			maps.Copy(sa.Annotations, want)
+			changed = slices.Contains(keys, clientIDAnnotation)
+			reason := "AnnotationsUpdated"
+			if changed {
+				reason = "ClientIDUpdated"
 			}
-			updateNeeded = true
+			r.event(&sa, corev1.EventTypeNormal, reason, annotationsUpdatedMessage(keys, want))
+			result.PatchedServiceAccounts = append(result.PatchedServiceAccounts, key)
+			serviceAccountsAnnotatedTotal.Inc()
+		}
+		// Check on earlier restarts first, so a failed rollout pauses the restarts below
+		if err := r.checkRollouts(ctx, &sa, identity, &result, log); err != nil {
+			log.Error(err, "Failed to check on workload rollouts", "ServiceAccount", key)
 		}
-		// trigger a restart of the deployment that is using the service account to ensure correct client ID is usee
-		if updateNeeded {
-			if err := r.restartDeployment(ctx, saName, ns.Name, log); err != nil {
-				log.Error(err, "Failed to restart deployment after updating service account annotation", "ServiceAccount", saName)
+		// trigger a restart of the workloads that are using the service account to ensure correct client ID is used,
+		// or resume restarts still pending from an earlier change
+		if changed || sa.Annotations[pendingRestartAnnotation] != "" {
+			if err := r.restartServiceAccountWorkloads(ctx, &sa, identity, changed, &result, log); err != nil {
+				log.Error(err, "Failed to restart workloads after updating service account annotation", "ServiceAccount", key)
 				continue
 			}
+		} else if r.RestartStalePodsOnly {
+			// Keep the stale pod count current once restarts are done
+			if _, err := r.staleWorkloads(ctx, &sa, time.Time{}, log); err != nil {
+				log.Error(err, "Failed to check pods for a stale client ID", "ServiceAccount", key)
+			}
 		}
-	}
-	return updateNeeded, nil
-}
-
-func (r *UserAssignedIdentityReconciler) restartDeployment(ctx context.Context, saName, namespace string, log logr.Logger) error {
-	var deployments appsv1.DeploymentList
-	// check what deployments are using the service account
-	if err := r.List(ctx, &deployments, client.InNamespace(namespace), client.MatchingFields(map[string]string{
-		"spec.template.spec.serviceAccountName": saName,
-	})); err != nil {
-		return err
-	}
-
-	for _, deployment := range deployments.Items {
-		// patch deploy with annotation to trigger restart
-		patch := client.MergeFrom(deployment.DeepCopy())
-		if deployment.Spec.Template.Annotations == nil {
-			deployment.Spec.Template.Annotations = map[string]string{}
-		}
-		deployment.Spec.Template.Annotations["azure.workload.identity/restart"] = time.Now().Format(time.RFC3339)
-		if err := r.Patch(ctx, &deployment, patch); err != nil {
-			log.Error(err, "Failed to add annotation to deployment", "Deployment", deployment.Name)
-			continue
+		if r.UseLabel {
+			if err := r.labelWorkloads(ctx, &sa, log); err != nil {
+				log.Error(err, "Failed to label workloads", "ServiceAccount", key)
+			}
 		}
-		log.Info("Successfully restarted deployment after updating service account annotation", "Deployment", deployment.Name)
 	}
-	return nil
+	return result, nil
 }
 
-func (r *UserAssignedIdentityReconciler) updateRoleAssignments(ctx context.Context, appName, principalID string, log logr.Logger) (bool, error) {
+// updateRoleAssignments points the RoleAssignments in scope matched by selector at principalID.
+func (r *UserAssignedIdentityReconciler) updateRoleAssignments(ctx context.Context, selector labels.Selector, scope roleAssignmentScope, principalID string, log logr.Logger) (roleAssignmentSync, error) {
+	var result roleAssignmentSync
 	if principalID == "" {
 		log.Error(fmt.Errorf("principalID is empty"), "Invalid principalID provided")
-		return false, fmt.Errorf("principalID is empty")
+		return result, fmt.Errorf("principalID is empty")
 	}
 
-	roleUpdateNeeded := false
-	selector := client.MatchingLabels{"application": appName, "type": "roleassignment"}
-
-	// Try namespaced RoleAssignments first
-	var roleAssignments ra.RoleAssignmentList
-	if err := r.Client.List(ctx, &roleAssignments, selector); err != nil {
-		log.V(1).Info("Could not list namespaced RoleAssignments", "error", err)
-	} else {
-		for _, roleAssignment := range roleAssignments.Items {
-			if roleAssignment.Spec.ForProvider.PrincipalID == nil || *roleAssignment.Spec.ForProvider.PrincipalID != principalID {
-				if roleAssignment.Spec.ForProvider.PrincipalID == nil {
-					roleAssignment.Spec.ForProvider.PrincipalID = new(string)
-				}
-				*roleAssignment.Spec.ForProvider.PrincipalID = principalID
-				if err := r.Client.Update(ctx, &roleAssignment); err != nil {
-					log.Error(err, "Failed to update namespaced RoleAssignment", "name", roleAssignment.Name)
-					continue
-				}
-				log.Info("Updated namespaced RoleAssignment", "name", roleAssignment.Name)
-				roleUpdateNeeded = true
-			}
+	for _, roleAssignment := range r.listRoleAssignments(ctx, selector, scope, log) {
+		if current := rolePrincipalID(roleAssignment); current != nil && *current == principalID {
+			continue
 		}
-	}
-
-	// Try cluster-scoped RoleAssignments
-	var clusterRoleAssignments ra2.RoleAssignmentList
-	if err := r.Client.List(ctx, &clusterRoleAssignments, selector); err != nil {
-		log.V(1).Info("Could not list cluster-scoped RoleAssignments", "error", err)
-	} else {
-		for _, roleAssignment := range clusterRoleAssignments.Items {
-			if roleAssignment.Spec.ForProvider.PrincipalID == nil || *roleAssignment.Spec.ForProvider.PrincipalID != principalID {
-				if roleAssignment.Spec.ForProvider.PrincipalID == nil {
-					roleAssignment.Spec.ForProvider.PrincipalID = new(string)
-				}
-				*roleAssignment.Spec.ForProvider.PrincipalID = principalID
-				if err := r.Client.Update(ctx, &roleAssignment); err != nil {
-					log.Error(err, "Failed to update cluster-scoped RoleAssignment", "name", roleAssignment.Name)
-					continue
-				}
-				log.Info("Updated cluster-scoped RoleAssignment", "name", roleAssignment.Name)
-				roleUpdateNeeded = true
-			}
+		if err := r.applyPrincipalID(ctx, roleAssignment, principalID); err != nil {
+			log.Error(err, "Failed to update RoleAssignment", "name", roleAssignment.GetName(), "namespace", roleAssignment.GetNamespace())
+			result.FailedRoleAssignments = append(result.FailedRoleAssignments, roleAssignment.GetName())
+			roleAssignmentUpdateFailuresTotal.Inc()
+			continue
 		}
+		log.Info("Updated RoleAssignment", "name", roleAssignment.GetName(), "namespace", roleAssignment.GetNamespace())
+		result.UpdatedRoleAssignments = append(result.UpdatedRoleAssignments, roleAssignment.GetName())
+		roleAssignmentsUpdatedTotal.Inc()
 	}
+	return result, nil
+}
 
-	return roleUpdateNeeded, nil
+// isBound reports whether any IdentityBinding references the identity behind key.
+func (r *UserAssignedIdentityReconciler) isBound(ctx context.Context, key types.NamespacedName) (bool, error) {
+	var bindings identityv1alpha1.IdentityBindingList
+	if err := r.List(ctx, &bindings, client.MatchingFields{bindingIdentityIndex: key.String()}); err != nil {
+		return false, err
+	}
+	return len(bindings.Items) > 0, nil
 }
 
-func extractAppName(managedIdentityName string) string {
-	parts := strings.Split(managedIdentityName, "-")
-	if len(parts) < 4 {
-		return ""
+// extractAppName derives the app name of identity using the configured extractor.
+func (r *UserAssignedIdentityReconciler) extractAppName(identity client.Object) (string, error) {
+	if r.AppNames == nil {
+		return defaultAppNameExtractor.ExtractAppName(identity)
 	}
-	// Assuming the app name is always the third part in e.g `id-service-appname-dv-azunea-001`
-	return parts[2]
+	return r.AppNames.ExtractAppName(identity)
 }
 
 func (r *UserAssignedIdentityReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appsv1.Deployment{}, "spec.template.spec.serviceAccountName", func(rawObj client.Object) []string {
-		deployment := rawObj.(*appsv1.Deployment)
-		return []string{deployment.Spec.Template.Spec.ServiceAccountName}
-	}); err != nil {
+	if err := setupServiceAccountIndex(mgr); err != nil {
+		return err
+	}
+	if err := r.setupWorkloadIndexes(mgr); err != nil {
+		return err
+	}
+	if err := r.setupIdentityIndexes(mgr); err != nil {
 		return err
 	}
 
-	// Watch namespaced UserAssignedIdentity (primary)
-	return ctrl.NewControllerManagedBy(mgr).
+	// ServiceAccounts and RoleAssignments are not necessarily owned by an identity, so they
+	// are mapped back to their identities by app name. FederatedIdentityCredentials are
+	// always controlled by theirs. Only changes to their metadata or spec
+	// matter, which keeps RoleAssignment status updates from Crossplane out of the queue.
+	serviceAccountChanged := predicate.Or(predicate.AnnotationChangedPredicate{}, predicate.LabelChangedPredicate{})
+	roleAssignmentChanged := predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{})
+
+	// Watch namespaced UserAssignedIdentity (primary) and cluster-scoped
+	// UserAssignedIdentity, which enqueues requests without a namespace
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&mi.UserAssignedIdentity{}).
-		Owns(&corev1.ServiceAccount{}).
-		Owns(&ra.RoleAssignment{}).
-		Owns(&ra2.RoleAssignment{}).
-		Complete(r)
+		Watches(&mi2.UserAssignedIdentity{}, &handler.EnqueueRequestForObject{}).
+		Owns(&mi.FederatedIdentityCredential{}).
+		Watches(&mi2.FederatedIdentityCredential{},
+			handler.EnqueueRequestForOwner(mgr.GetScheme(), mgr.GetRESTMapper(), &mi2.UserAssignedIdentity{}, handler.OnlyControllerOwner())).
+		Watches(&corev1.ServiceAccount{}, handler.EnqueueRequestsFromMapFunc(r.identitiesForServiceAccount),
+			builder.WithPredicates(serviceAccountChanged)).
+		Watches(&ra.RoleAssignment{}, handler.EnqueueRequestsFromMapFunc(r.identitiesForRoleAssignment),
+			builder.WithPredicates(roleAssignmentChanged)).
+		Watches(&ra2.RoleAssignment{}, handler.EnqueueRequestsFromMapFunc(r.identitiesForRoleAssignment),
+			builder.WithPredicates(roleAssignmentChanged))
+	if r.IdentityBindings {
+		// Binding or unbinding an identity switches it between the convention and the binding
+		b = b.Watches(&identityv1alpha1.IdentityBinding{}, handler.EnqueueRequestsFromMapFunc(identityForBinding))
+	}
+	return b.Complete(r)
 }
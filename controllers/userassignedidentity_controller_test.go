@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -9,17 +10,52 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	identityv1alpha1 "github.com/fortytwoservices/clientid-operator/api/v1alpha1"
 	ra2 "github.com/upbound/provider-azure/v2/apis/cluster/authorization/v1beta1"
 	mi2 "github.com/upbound/provider-azure/v2/apis/cluster/managedidentity/v1beta1"
 	ra "github.com/upbound/provider-azure/v2/apis/namespaced/authorization/v1beta1"
 	mi "github.com/upbound/provider-azure/v2/apis/namespaced/managedidentity/v1beta1"
 )
 
+// withIndexes registers the field indexes set up by SetupWithManager on the fake client.
+func withIndexes(b *fake.ClientBuilder) *fake.ClientBuilder {
+	_ = identityv1alpha1.AddToScheme(scheme.Scheme)
+	b = b.WithIndex(&corev1.ServiceAccount{}, serviceAccountAppIndex, serviceAccountApp)
+	b = b.WithIndex(&corev1.Pod{}, podServiceAccountIndex, indexPodServiceAccount)
+	identities := &UserAssignedIdentityReconciler{}
+	b = b.WithIndex(&mi.UserAssignedIdentity{}, identityAppIndex, identities.indexIdentityApp)
+	b = b.WithIndex(&mi2.UserAssignedIdentity{}, identityAppIndex, identities.indexIdentityApp)
+	b = b.WithIndex(&identityv1alpha1.IdentityBinding{}, bindingIdentityIndex, indexBindingIdentity)
+	for _, kind := range workloadKinds {
+		b = b.WithIndex(kind.object(), serviceAccountIndex, kind.indexServiceAccount)
+	}
+	return b
+}
+
+// newTestReconciler returns a reconciler on a fake client seeded with objs and indexed like
+// the manager, and the FakeRecorder receiving its Events.
+func newTestReconciler(t *testing.T, objs ...client.Object) (client.Client, *UserAssignedIdentityReconciler, *record.FakeRecorder) {
+	t.Helper()
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = ra2.AddToScheme(s)
+
+	cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+	recorder := record.NewFakeRecorder(20)
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), Recorder: recorder}
+	return cl, r, recorder
+}
+
 func TestUserAssignedIdentityReconciler_Reconcile(t *testing.T) {
 	// Register schemes
 	s := scheme.Scheme
@@ -111,20 +147,18 @@ func TestUserAssignedIdentityReconciler_Reconcile(t *testing.T) {
 	objs := []client.Object{identity, sa, deployment, roleAssignment, ns}
 
 	// Create fake client
-	cl := fake.NewClientBuilder().
+	cl := withIndexes(fake.NewClientBuilder()).
 		WithScheme(s).
 		WithObjects(objs...).
-		WithIndex(&appsv1.Deployment{}, "spec.template.spec.serviceAccountName", func(rawObj client.Object) []string {
-			deployment := rawObj.(*appsv1.Deployment)
-			return []string{deployment.Spec.Template.Spec.ServiceAccountName}
-		}).
 		Build()
 
 	// Reconciler
+	recorder := record.NewFakeRecorder(10)
 	r := &UserAssignedIdentityReconciler{
-		Client: cl,
-		Scheme: s,
-		Log:    zap.New(zap.UseDevMode(true)),
+		Client:   cl,
+		Scheme:   s,
+		Log:      zap.New(zap.UseDevMode(true)),
+		Recorder: recorder,
 	}
 
 	// Create Request
@@ -171,4 +205,262 @@ func TestUserAssignedIdentityReconciler_Reconcile(t *testing.T) {
 	if *updatedRA.Spec.ForProvider.PrincipalID != principalID {
 		t.Errorf("RoleAssignment PrincipalID incorrect. Expected %s, got %s", principalID, *updatedRA.Spec.ForProvider.PrincipalID)
 	}
+
+	// Verify sync summary on the identity
+	updatedIdentity := &mi.UserAssignedIdentity{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: identityName, Namespace: namespace}, updatedIdentity); err != nil {
+		t.Fatalf("Failed to get UserAssignedIdentity: %v", err)
+	}
+	for key, want := range map[string]string{
+		"clientid-operator/last-sync-result":         "Synced",
+		"clientid-operator/service-accounts-patched": "1",
+		"clientid-operator/role-assignments-updated": "1",
+		"clientid-operator/workloads-restarted":      "1",
+	} {
+		if got := updatedIdentity.Annotations[key]; got != want {
+			t.Errorf("Identity annotation %s incorrect. Expected %s, got %s", key, want, got)
+		}
+	}
+	if _, ok := updatedIdentity.Annotations["clientid-operator/last-sync-time"]; !ok {
+		t.Error("Identity missing last-sync-time annotation")
+	}
+
+	// Verify Events on the ServiceAccount, Deployment and identity
+	close(recorder.Events)
+	var reasons []string
+	for event := range recorder.Events {
+		reasons = append(reasons, event)
+	}
+	for _, want := range []string{"Normal ClientIDUpdated", "Normal Restarted", "Normal Synced"} {
+		found := false
+		for _, event := range reasons {
+			if strings.HasPrefix(event, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected event %q, got %v", want, reasons)
+		}
+	}
+}
+
+func TestUserAssignedIdentityReconciler_ReconcileMissingIDs(t *testing.T) {
+	s := scheme.Scheme
+	_ = mi.AddToScheme(s)
+
+	identityName := "id-service-pending-dv-azunea-001"
+	namePtr := identityName
+	identity := &mi.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: identityName, Namespace: "default"},
+		Spec: mi.UserAssignedIdentitySpec{
+			ForProvider: mi.UserAssignedIdentityParameters{Name: &namePtr},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(identity).Build()
+
+	recorder := record.NewFakeRecorder(10)
+	r := &UserAssignedIdentityReconciler{
+		Client:   cl,
+		Scheme:   s,
+		Log:      zap.New(zap.UseDevMode(true)),
+		Recorder: recorder,
+	}
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: identityName, Namespace: "default"}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("Expected identity without IDs to be requeued")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning MissingIDs") {
+			t.Errorf("Unexpected event %q", event)
+		}
+	default:
+		t.Error("Expected a MissingIDs event")
+	}
+
+	updatedIdentity := &mi.UserAssignedIdentity{}
+	if err := cl.Get(ctx, key, updatedIdentity); err != nil {
+		t.Fatalf("Failed to get UserAssignedIdentity: %v", err)
+	}
+	if got := updatedIdentity.Annotations["clientid-operator/last-sync-result"]; got != "MissingIDs" {
+		t.Errorf("Identity last-sync-result incorrect. Expected MissingIDs, got %s", got)
+	}
+}
+
+func TestUserAssignedIdentityReconciler_ReconcileClusterScoped(t *testing.T) {
+	// Register schemes
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = mi.AddToScheme(s)
+	_ = mi2.AddToScheme(s)
+	_ = ra.AddToScheme(s)
+	_ = ra2.AddToScheme(s)
+
+	// Test data
+	namespace := "team-a"
+	identityName := "id-service-clusterapp-dv-azunea-001"
+	appName := "clusterapp"
+	clientID := "cluster-client-id"
+	principalID := "cluster-principal-id"
+	oldPrincipalID := "old-principal-id"
+
+	// 1. Cluster-scoped UserAssignedIdentity
+	namePtr := identityName
+	identity := &mi2.UserAssignedIdentity{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: identityName,
+		},
+		Spec: mi2.UserAssignedIdentitySpec{
+			ForProvider: mi2.UserAssignedIdentityParameters{
+				Name: &namePtr,
+			},
+		},
+		Status: mi2.UserAssignedIdentityStatus{
+			AtProvider: mi2.UserAssignedIdentityObservation{
+				ClientID:    &clientID,
+				PrincipalID: &principalID,
+			},
+		},
+	}
+
+	// 2. ServiceAccount
+	saName := "workload-identity-" + appName
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      saName,
+			Namespace: namespace,
+		},
+	}
+
+	// 3. Cluster-scoped RoleAssignment
+	raName := "test-cluster-role-assignment"
+	roleAssignment := &ra2.RoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: raName,
+			Labels: map[string]string{
+				"application": appName,
+				"type":        "roleassignment",
+			},
+		},
+		Spec: ra2.RoleAssignmentSpec{
+			ForProvider: ra2.RoleAssignmentParameters{
+				PrincipalID: &oldPrincipalID, // Needs update
+			},
+		},
+	}
+
+	// 4. Namespace
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+		},
+	}
+
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(identity, sa, roleAssignment, ns).
+		Build()
+
+	r := &UserAssignedIdentityReconciler{
+		Client: cl,
+		Scheme: s,
+		Log:    zap.New(zap.UseDevMode(true)),
+	}
+	ctx := context.Background()
+
+	// A namespaced request sharing the identity's name must not reach the cluster path
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: identityName, Namespace: namespace}}); err != nil {
+		t.Fatalf("Reconcile of namespaced request failed: %v", err)
+	}
+	untouchedSA := &corev1.ServiceAccount{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: saName, Namespace: namespace}, untouchedSA); err != nil {
+		t.Fatalf("Failed to get ServiceAccount: %v", err)
+	}
+	if _, ok := untouchedSA.Annotations["azure.workload.identity/client-id"]; ok {
+		t.Error("Namespaced request unexpectedly reconciled the cluster-scoped identity")
+	}
+
+	// Cluster-scoped identities are enqueued without a namespace
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: identityName}}); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	// Verify ServiceAccount Update
+	updatedSA := &corev1.ServiceAccount{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: saName, Namespace: namespace}, updatedSA); err != nil {
+		t.Fatalf("Failed to get ServiceAccount: %v", err)
+	}
+	if val, ok := updatedSA.Annotations["azure.workload.identity/client-id"]; !ok || val != clientID {
+		t.Errorf("ServiceAccount annotation incorrect. Expected %s, got %s", clientID, val)
+	}
+
+	// Verify cluster-scoped RoleAssignment Update
+	updatedRA := &ra2.RoleAssignment{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: raName}, updatedRA); err != nil {
+		t.Fatalf("Failed to get RoleAssignment: %v", err)
+	}
+	if *updatedRA.Spec.ForProvider.PrincipalID != principalID {
+		t.Errorf("RoleAssignment PrincipalID incorrect. Expected %s, got %s", principalID, *updatedRA.Spec.ForProvider.PrincipalID)
+	}
+}
+
+func TestUserAssignedIdentityReconciler_UpdateServiceAccounts(t *testing.T) {
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+
+	saName := "workload-identity-testapp"
+	clientID := "test-client-id"
+
+	// The ServiceAccount in "alpha" is stale, the one in "beta" is already current
+	stale := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: saName, Namespace: "alpha"}}
+	current := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        saName,
+		Namespace:   "beta",
+		Annotations: map[string]string{"azure.workload.identity/client-id": clientID},
+	}}
+	deployment := func(namespace string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace},
+			Spec:       appsv1.DeploymentSpec{Template: podTemplate(saName)},
+		}
+	}
+
+	cl := withIndexes(fake.NewClientBuilder()).
+		WithScheme(s).
+		WithObjects(stale, current, deployment("alpha"), deployment("beta")).
+		Build()
+	r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true))}
+
+	ctx := context.Background()
+	alpha := types.NamespacedName{Name: saName, Namespace: "alpha"}
+	beta := types.NamespacedName{Name: saName, Namespace: "beta"}
+	result, err := r.updateServiceAccounts(ctx, []types.NamespacedName{alpha, beta}, workloadIdentity{ClientID: clientID}, r.Log)
+	if err != nil {
+		t.Fatalf("updateServiceAccounts failed: %v", err)
+	}
+
+	if len(result.PatchedServiceAccounts) != 1 || result.PatchedServiceAccounts[0] != alpha {
+		t.Errorf("PatchedServiceAccounts incorrect. Expected [%s], got %v", alpha, result.PatchedServiceAccounts)
+	}
+	wantRestart := workloadRef{Kind: WorkloadKindDeployment, NamespacedName: types.NamespacedName{Name: "app", Namespace: "alpha"}}
+	if len(result.RestartedWorkloads) != 1 || result.RestartedWorkloads[0] != wantRestart {
+		t.Errorf("RestartedWorkloads incorrect. Expected [%s], got %v", wantRestart, result.RestartedWorkloads)
+	}
+
+	untouched := &appsv1.Deployment{}
+	if err := cl.Get(ctx, types.NamespacedName{Name: "app", Namespace: "beta"}, untouched); err != nil {
+		t.Fatalf("Failed to get Deployment: %v", err)
+	}
+	if _, ok := untouched.Spec.Template.Annotations["azure.workload.identity/restart"]; ok {
+		t.Error("Deployment using an unchanged ServiceAccount was restarted")
+	}
 }
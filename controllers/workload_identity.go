@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	clientIDAnnotation        = "azure.workload.identity/client-id"
+	tenantIDAnnotation        = "azure.workload.identity/tenant-id"
+	tokenExpirationAnnotation = "azure.workload.identity/service-account-token-expiration"
+	// useLabel on a pod makes the Azure Workload Identity webhook inject the identity.
+	useLabel = "azure.workload.identity/use"
+
+	minTokenExpiration = time.Hour
+	maxTokenExpiration = 24 * time.Hour
+)
+
+// workloadIdentity holds the values of an identity that are propagated to its ServiceAccounts.
+type workloadIdentity struct {
+	ClientID string
+	TenantID string
+	// Owner is the UserAssignedIdentity or IdentityBinding the ServiceAccounts are synced for.
+	// Failed rollouts are reported on it, and it can pause the restarts of its workloads.
+	Owner client.Object
+}
+
+// ValidateTokenExpiration checks that expiration is zero, which leaves the annotation
+// unset, or a whole number of seconds within the range the webhook accepts.
+func ValidateTokenExpiration(expiration time.Duration) error {
+	if expiration == 0 {
+		return nil
+	}
+	if expiration < minTokenExpiration || expiration > maxTokenExpiration || expiration%time.Second != 0 {
+		return fmt.Errorf("token expiration %s must be whole seconds between %s and %s", expiration, minTokenExpiration, maxTokenExpiration)
+	}
+	return nil
+}
+
+// serviceAccountAnnotations returns the annotations the operator sets on a ServiceAccount of identity.
+func (r *UserAssignedIdentityReconciler) serviceAccountAnnotations(identity workloadIdentity) map[string]string {
+	annotations := map[string]string{clientIDAnnotation: identity.ClientID}
+	if r.PropagateTenantID && identity.TenantID != "" {
+		annotations[tenantIDAnnotation] = identity.TenantID
+	}
+	if r.TokenExpiration > 0 {
+		annotations[tokenExpirationAnnotation] = strconv.FormatInt(int64(r.TokenExpiration/time.Second), 10)
+	}
+	return annotations
+}
+
+// changedAnnotations returns the keys of want whose values differ on sa, sorted.
+func changedAnnotations(sa *corev1.ServiceAccount, want map[string]string) []string {
+	var changed []string
+	for _, key := range slices.Sorted(maps.Keys(want)) {
+		if sa.Annotations[key] != want[key] {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// annotationsUpdatedMessage describes the annotations set on a ServiceAccount.
+func annotationsUpdatedMessage(keys []string, want map[string]string) string {
+	set := make([]string, 0, len(keys))
+	for _, key := range keys {
+		set = append(set, fmt.Sprintf("%s to %s", key, want[key]))
+	}
+	return "Set " + strings.Join(set, ", ")
+}
+
+// labelWorkloads adds the azure.workload.identity/use label to the pod templates of the
+// configured workloads using sa that lack it, which rolls their pods out once.
+func (r *UserAssignedIdentityReconciler) labelWorkloads(ctx context.Context, sa *corev1.ServiceAccount, log logr.Logger) error {
+	var errs []error
+	for _, kind := range r.workloads() {
+		workloads, err := r.workloadsUsing(ctx, kind, sa.Name, sa.Namespace)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", kind.kind, err))
+			continue
+		}
+		for _, workload := range workloads {
+			if kind.templateLabel(workload, useLabel) == "true" {
+				continue
+			}
+			patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+			if err := kind.labelTemplate(workload, useLabel, "true"); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := r.Patch(ctx, workload, patch); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", kind.kind, workload.GetName(), err))
+				continue
+			}
+			log.Info("Added azure.workload.identity/use label to workload", "kind", kind.kind, "name", workload.GetName())
+			r.event(workload, corev1.EventTypeNormal, "UseLabelAdded", fmt.Sprintf("Added %s=true to the pod template for ServiceAccount %s", useLabel, sa.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestValidateTokenExpiration(t *testing.T) {
+	tests := []struct {
+		expiration time.Duration
+		wantErr    bool
+	}{
+		{expiration: 0},
+		{expiration: time.Hour},
+		{expiration: 24 * time.Hour},
+		{expiration: 30 * time.Minute, wantErr: true},
+		{expiration: 25 * time.Hour, wantErr: true},
+		{expiration: time.Hour + time.Millisecond, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateTokenExpiration(tt.expiration); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateTokenExpiration(%s) error = %v, wantErr %v", tt.expiration, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUpdateServiceAccounts_Annotations(t *testing.T) {
+	identity := workloadIdentity{ClientID: "test-client-id", TenantID: "test-tenant-id"}
+	tests := []struct {
+		name            string
+		tenantID        bool
+		tokenExpiration time.Duration
+		want            map[string]string
+	}{
+		{name: "client ID only", want: map[string]string{clientIDAnnotation: "test-client-id"}},
+		{
+			name:     "tenant ID",
+			tenantID: true,
+			want:     map[string]string{clientIDAnnotation: "test-client-id", tenantIDAnnotation: "test-tenant-id"},
+		},
+		{
+			name:            "token expiration",
+			tokenExpiration: 2 * time.Hour,
+			want:            map[string]string{clientIDAnnotation: "test-client-id", tokenExpirationAnnotation: "7200"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl, r, _ := newTestReconciler(t, restartPolicyObjects(nil)...)
+			r.PropagateTenantID = tt.tenantID
+			r.TokenExpiration = tt.tokenExpiration
+			key := types.NamespacedName{Name: restartTestSA, Namespace: "default"}
+
+			result, err := r.updateServiceAccounts(context.Background(), []types.NamespacedName{key}, identity, r.Log)
+			if err != nil {
+				t.Fatalf("updateServiceAccounts() error = %v", err)
+			}
+			if len(result.PatchedServiceAccounts) != 1 {
+				t.Errorf("Expected the ServiceAccount to be patched, got %v", result.PatchedServiceAccounts)
+			}
+
+			var sa corev1.ServiceAccount
+			_ = cl.Get(context.Background(), key, &sa)
+			for _, key := range []string{clientIDAnnotation, tenantIDAnnotation, tokenExpirationAnnotation} {
+				if got := sa.Annotations[key]; got != tt.want[key] {
+					t.Errorf("Annotation %s incorrect. Expected %q, got %q", key, tt.want[key], got)
+				}
+			}
+
+			// A second sync finds nothing to change
+			result, err = r.updateServiceAccounts(context.Background(), []types.NamespacedName{key}, identity, r.Log)
+			if err != nil {
+				t.Fatalf("updateServiceAccounts() error = %v", err)
+			}
+			if len(result.PatchedServiceAccounts) != 0 {
+				t.Errorf("Expected no changes on the second sync, got %v", result.PatchedServiceAccounts)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceAccounts_TenantIDOnlyChange(t *testing.T) {
+	_, r, recorder := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "test-client-id"})...)
+	r.PropagateTenantID = true
+
+	result := syncServiceAccount(t, r, "test-client-id")
+	if len(result.PatchedServiceAccounts) != 0 {
+		t.Errorf("Expected no change without a tenant ID, got %v", result.PatchedServiceAccounts)
+	}
+
+	key := types.NamespacedName{Name: restartTestSA, Namespace: "default"}
+	identity := workloadIdentity{ClientID: "test-client-id", TenantID: "test-tenant-id"}
+	result, err := r.updateServiceAccounts(context.Background(), []types.NamespacedName{key}, identity, r.Log)
+	if err != nil {
+		t.Fatalf("updateServiceAccounts() error = %v", err)
+	}
+	if len(result.PatchedServiceAccounts) != 1 {
+		t.Errorf("Expected the tenant ID to be added, got %v", result.PatchedServiceAccounts)
+	}
+	if len(result.RestartedWorkloads) != 0 || len(result.PendingRestarts) != 0 {
+		t.Errorf("Expected no restart without a new client ID, got %v", result.RestartedWorkloads)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Normal AnnotationsUpdated") {
+		t.Errorf("Unexpected event %q", event)
+	}
+}
+
+func TestLabelWorkloads(t *testing.T) {
+	cl, r, _ := newTestReconciler(t, restartPolicyObjects(map[string]string{clientIDAnnotation: "test-client-id"})...)
+	r.UseLabel = true
+	ctx := context.Background()
+
+	// One workload already carries the label and must not be rolled out again
+	var labelled appsv1.Deployment
+	_ = cl.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &labelled)
+	labelled.Spec.Template.Labels = map[string]string{useLabel: "true"}
+	if err := cl.Update(ctx, &labelled); err != nil {
+		t.Fatalf("Failed to update Deployment: %v", err)
+	}
+	_ = cl.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &labelled)
+	resourceVersion := labelled.ResourceVersion
+
+	syncServiceAccount(t, r, "test-client-id")
+
+	var first appsv1.Deployment
+	_ = cl.Get(ctx, types.NamespacedName{Name: "first", Namespace: "default"}, &first)
+	if got := first.Spec.Template.Labels[useLabel]; got != "true" {
+		t.Errorf("Use label missing. Expected true, got %q", got)
+	}
+	_ = cl.Get(ctx, types.NamespacedName{Name: "second", Namespace: "default"}, &labelled)
+	if labelled.ResourceVersion != resourceVersion {
+		t.Error("Expected the labelled Deployment to be left alone")
+	}
+
+	// A restart labels the pod template in the same patch
+	syncServiceAccount(t, r, "new-client-id")
+	var deployments appsv1.DeploymentList
+	_ = cl.List(ctx, &deployments)
+	for _, d := range deployments.Items {
+		if d.Spec.Template.Labels[useLabel] != "true" || d.Spec.Template.Annotations[restartAnnotation] == "" {
+			t.Errorf("Deployment %s not restarted with the use label: %v", d.Name, d.Spec.Template.ObjectMeta)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	WorkloadKindDeployment  = "Deployment"
+	WorkloadKindStatefulSet = "StatefulSet"
+	WorkloadKindDaemonSet   = "DaemonSet"
+	WorkloadKindCronJob     = "CronJob"
+	// WorkloadKindRollout is an Argo Rollout, handled as unstructured so the operator
+	// does not depend on the Argo API. Requires the Rollout CRD to be installed.
+	WorkloadKindRollout = "Rollout"
+
+	// serviceAccountIndex indexes every workload kind by the ServiceAccount of its pod template.
+	serviceAccountIndex = "spec.template.spec.serviceAccountName"
+
+	restartAnnotation = "azure.workload.identity/restart"
+)
+
+// DefaultWorkloadKinds are restarted unless configured otherwise.
+var DefaultWorkloadKinds = []string{WorkloadKindDeployment, WorkloadKindStatefulSet, WorkloadKindDaemonSet, WorkloadKindCronJob}
+
+var rolloutGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}
+
+// workloadKind describes how to find and restart one kind of pod-template-bearing workload.
+type workloadKind struct {
+	kind               string
+	object             func() client.Object
+	list               func() client.ObjectList
+	serviceAccountName func(obj client.Object) string
+	templateAnnotation func(obj client.Object, key string) string
+	annotateTemplate   func(obj client.Object, key, value string) error
+	templateLabel      func(obj client.Object, key string) string
+	labelTemplate      func(obj client.Object, key, value string) error
+	// rolledOut reports whether the pods of the workload all run its current template
+	rolledOut func(obj client.Object) bool
+	// rolloutFailed reports whether the rollout of the current template has failed, and why
+	rolloutFailed func(obj client.Object) (bool, string)
+}
+
+var workloadKinds = map[string]workloadKind{
+	WorkloadKindDeployment: typedWorkload(WorkloadKindDeployment,
+		func() client.Object { return &appsv1.Deployment{} },
+		func() client.ObjectList { return &appsv1.DeploymentList{} },
+		func(obj client.Object) *corev1.PodTemplateSpec { return &obj.(*appsv1.Deployment).Spec.Template },
+		func(obj client.Object) bool {
+			d := obj.(*appsv1.Deployment)
+			replicas := ptr.Deref(d.Spec.Replicas, 1)
+			return d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+				d.Status.Replicas == replicas && d.Status.AvailableReplicas == replicas
+		},
+		func(obj client.Object) (bool, string) {
+			d := obj.(*appsv1.Deployment)
+			if d.Status.ObservedGeneration < d.Generation {
+				return false, ""
+			}
+			// A deadline exceeded before the restart belongs to an earlier rollout
+			started, _ := time.Parse(time.RFC3339, d.Annotations[rolloutStartedAnnotation])
+			for _, condition := range d.Status.Conditions {
+				if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse &&
+					condition.Reason == deploymentProgressDeadlineExceeded && !condition.LastUpdateTime.Time.Before(started) {
+					return true, condition.Message
+				}
+			}
+			return false, ""
+		}),
+	WorkloadKindStatefulSet: typedWorkload(WorkloadKindStatefulSet,
+		func() client.Object { return &appsv1.StatefulSet{} },
+		func() client.ObjectList { return &appsv1.StatefulSetList{} },
+		func(obj client.Object) *corev1.PodTemplateSpec { return &obj.(*appsv1.StatefulSet).Spec.Template },
+		func(obj client.Object) bool {
+			sts := obj.(*appsv1.StatefulSet)
+			replicas := ptr.Deref(sts.Spec.Replicas, 1)
+			return sts.Status.ObservedGeneration >= sts.Generation && sts.Status.UpdatedReplicas == replicas &&
+				sts.Status.ReadyReplicas == replicas
+		},
+		noRolloutDeadline),
+	WorkloadKindDaemonSet: typedWorkload(WorkloadKindDaemonSet,
+		func() client.Object { return &appsv1.DaemonSet{} },
+		func() client.ObjectList { return &appsv1.DaemonSetList{} },
+		func(obj client.Object) *corev1.PodTemplateSpec { return &obj.(*appsv1.DaemonSet).Spec.Template },
+		func(obj client.Object) bool {
+			ds := obj.(*appsv1.DaemonSet)
+			return ds.Status.ObservedGeneration >= ds.Generation &&
+				ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+				ds.Status.NumberAvailable == ds.Status.DesiredNumberScheduled
+		},
+		noRolloutDeadline),
+	// Only Jobs created after the restart pick up the new client ID
+	WorkloadKindCronJob: typedWorkload(WorkloadKindCronJob,
+		func() client.Object { return &batchv1.CronJob{} },
+		func() client.ObjectList { return &batchv1.CronJobList{} },
+		func(obj client.Object) *corev1.PodTemplateSpec {
+			return &obj.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template
+		},
+		// There is no rollout to wait for
+		func(client.Object) bool { return true },
+		noRolloutDeadline),
+	WorkloadKindRollout: {
+		kind: WorkloadKindRollout,
+		object: func() client.Object {
+			rollout := &unstructured.Unstructured{}
+			rollout.SetGroupVersionKind(rolloutGVK)
+			return rollout
+		},
+		list: func() client.ObjectList {
+			rollouts := &unstructured.UnstructuredList{}
+			rollouts.SetGroupVersionKind(rolloutGVK.GroupVersion().WithKind(rolloutGVK.Kind + "List"))
+			return rollouts
+		},
+		serviceAccountName: func(obj client.Object) string {
+			name, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "spec", "template", "spec", "serviceAccountName")
+			return name
+		},
+		templateAnnotation: func(obj client.Object, key string) string {
+			value, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "spec", "template", "metadata", "annotations", key)
+			return value
+		},
+		annotateTemplate: func(obj client.Object, key, value string) error {
+			return unstructured.SetNestedField(obj.(*unstructured.Unstructured).Object, value, "spec", "template", "metadata", "annotations", key)
+		},
+		templateLabel: func(obj client.Object, key string) string {
+			value, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "spec", "template", "metadata", "labels", key)
+			return value
+		},
+		labelTemplate: func(obj client.Object, key, value string) error {
+			return unstructured.SetNestedField(obj.(*unstructured.Unstructured).Object, value, "spec", "template", "metadata", "labels", key)
+		},
+		rolledOut: func(obj client.Object) bool {
+			phase, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "status", "phase")
+			return phase == "Healthy"
+		},
+		rolloutFailed: func(obj client.Object) (bool, string) {
+			phase, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "status", "phase")
+			message, _, _ := unstructured.NestedString(obj.(*unstructured.Unstructured).Object, "status", "message")
+			return phase == "Degraded", message
+		},
+	},
+}
+
+func typedWorkload(kind string, object func() client.Object, list func() client.ObjectList, template func(client.Object) *corev1.PodTemplateSpec,
+	rolledOut func(client.Object) bool, rolloutFailed func(client.Object) (bool, string)) workloadKind {
+	return workloadKind{
+		kind:          kind,
+		object:        object,
+		list:          list,
+		rolledOut:     rolledOut,
+		rolloutFailed: rolloutFailed,
+		serviceAccountName: func(obj client.Object) string {
+			return template(obj).Spec.ServiceAccountName
+		},
+		templateAnnotation: func(obj client.Object, key string) string {
+			return template(obj).Annotations[key]
+		},
+		annotateTemplate: func(obj client.Object, key, value string) error {
+			tmpl := template(obj)
+			if tmpl.Annotations == nil {
+				tmpl.Annotations = map[string]string{}
+			}
+			tmpl.Annotations[key] = value
+			return nil
+		},
+		templateLabel: func(obj client.Object, key string) string {
+			return template(obj).Labels[key]
+		},
+		labelTemplate: func(obj client.Object, key, value string) error {
+			tmpl := template(obj)
+			if tmpl.Labels == nil {
+				tmpl.Labels = map[string]string{}
+			}
+			tmpl.Labels[key] = value
+			return nil
+		},
+	}
+}
+
+// noRolloutDeadline is the rolloutFailed of kinds whose rollouts never time out.
+func noRolloutDeadline(client.Object) (bool, string) {
+	return false, ""
+}
+
+// ValidateWorkloadKinds checks that every kind in kinds can be restarted.
+func ValidateWorkloadKinds(kinds []string) error {
+	for _, kind := range kinds {
+		if _, ok := workloadKinds[kind]; !ok {
+			return fmt.Errorf("unknown workload kind %q, must be one of %s, %s", kind,
+				strings.Join(DefaultWorkloadKinds, ", "), WorkloadKindRollout)
+		}
+	}
+	return nil
+}
+
+// indexServiceAccount is the field index function for serviceAccountIndex.
+func (k workloadKind) indexServiceAccount(obj client.Object) []string {
+	return []string{k.serviceAccountName(obj)}
+}
+
+// workloads returns the configured workload kinds.
+func (r *UserAssignedIdentityReconciler) workloads() []workloadKind {
+	names := r.WorkloadKinds
+	if names == nil {
+		names = DefaultWorkloadKinds
+	}
+	kinds := make([]workloadKind, 0, len(names))
+	for _, name := range names {
+		if kind, ok := workloadKinds[name]; ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// restartWorkloads restarts every configured workload in only whose pods run as the
+// ServiceAccount saName in namespace and that has not been restarted since, so they pick up
+// its new client ID. It reports false when a restart could not complete yet.
+func (r *UserAssignedIdentityReconciler) restartWorkloads(ctx context.Context, saName, namespace string, since time.Time, only workloadSet, result *serviceAccountSync, log logr.Logger) (bool, error) {
+	done := true
+	var errs []error
+	for _, kind := range r.workloads() {
+		kindDone, err := r.restartWorkloadsOfKind(ctx, kind, saName, namespace, since, only, result, log)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", kind.kind, err))
+		}
+		done = done && kindDone
+	}
+	return done, errors.Join(errs...)
+}
+
+func (r *UserAssignedIdentityReconciler) restartWorkloadsOfKind(ctx context.Context, kind workloadKind, saName, namespace string, since time.Time, only workloadSet, result *serviceAccountSync, log logr.Logger) (bool, error) {
+	workloads, err := r.workloadsUsing(ctx, kind, saName, namespace)
+	if err != nil {
+		return false, err
+	}
+	done := true
+	for _, workload := range workloads {
+		if !only.has(kind.kind, workload) {
+			log.V(1).Info("Workload has no pods with a stale client ID, skipping restart", "kind", kind.kind, "name", workload.GetName())
+			continue
+		}
+		restarted, err := r.restartStrategyFor(workload).restarted(ctx, r, kind, workload, since)
+		if err != nil {
+			return false, err
+		}
+		if restarted {
+			continue
+		}
+		workloadDone, err := r.restartWorkload(ctx, kind, workload, saName, since, result, log)
+		if err != nil {
+			return false, err
+		}
+		done = done && workloadDone
+	}
+	return done, nil
+}
+
+// workloadsUsing lists the workloads of kind in namespace whose pods run as saName.
+func (r *UserAssignedIdentityReconciler) workloadsUsing(ctx context.Context, kind workloadKind, saName, namespace string) ([]client.Object, error) {
+	list := kind.list()
+	if err := r.List(ctx, list, client.InNamespace(namespace), client.MatchingFields{serviceAccountIndex: saName}); err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	workloads := make([]client.Object, 0, len(items))
+	for _, item := range items {
+		workloads = append(workloads, item.(client.Object))
+	}
+	return workloads, nil
+}
+
+// restartWorkload restarts the pods of workload started before since with its restart strategy,
+// and reports false when some could not be restarted yet. A failed restart is reported as an
+// event rather than an error, so the remaining workloads are still restarted, and stays
+// pending to be retried by a later reconcile.
+func (r *UserAssignedIdentityReconciler) restartWorkload(ctx context.Context, kind workloadKind, workload client.Object, saName string, since time.Time, result *serviceAccountSync, log logr.Logger) (bool, error) {
+	done, err := r.restartStrategyFor(workload).restart(ctx, r, kind, workload, since)
+	if err != nil {
+		log.Error(err, "Failed to restart workload", "kind", kind.kind, "name", workload.GetName())
+		r.event(workload, corev1.EventTypeWarning, "RestartFailed", fmt.Sprintf("Failed to restart after client ID change on ServiceAccount %s: %v", saName, err))
+		return false, nil
+	}
+	if !done {
+		result.requeue(evictionRetryInterval)
+		return false, nil
+	}
+	r.event(workload, corev1.EventTypeNormal, "Restarted", fmt.Sprintf("Restarted after client ID change on ServiceAccount %s", saName))
+	result.RestartedWorkloads = append(result.RestartedWorkloads, workloadRef{Kind: kind.kind, NamespacedName: client.ObjectKeyFromObject(workload)})
+	workloadRestartsTotal.WithLabelValues(kind.kind).Inc()
+	log.Info("Successfully restarted workload after updating service account annotation", "kind", kind.kind, "name", workload.GetName())
+	return done, nil
+}
+
+// setupWorkloadIndexes registers the ServiceAccount index for every configured workload kind,
+// and for Pods, which stale pod detection and the evict strategy look up. Any workload can
+// opt into evict with its restart strategy annotation, so the Pod index is always registered.
+func (r *UserAssignedIdentityReconciler) setupWorkloadIndexes(mgr ctrl.Manager) error {
+	for _, kind := range r.workloads() {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), kind.object(), serviceAccountIndex, kind.indexServiceAccount); err != nil {
+			return fmt.Errorf("failed to index %s by ServiceAccount: %w", kind.kind, err)
+		}
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podServiceAccountIndex, indexPodServiceAccount); err != nil {
+		return fmt.Errorf("failed to index Pods by ServiceAccount: %w", err)
+	}
+	return nil
+}
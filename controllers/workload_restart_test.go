@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func podTemplate(saName string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{Spec: corev1.PodSpec{ServiceAccountName: saName}}
+}
+
+func rollout(name, namespace, saName string) *unstructured.Unstructured {
+	obj := workloadKinds[WorkloadKindRollout].object().(*unstructured.Unstructured)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+	_ = unstructured.SetNestedField(obj.Object, saName, "spec", "template", "spec", "serviceAccountName")
+	return obj
+}
+
+func TestValidateWorkloadKinds(t *testing.T) {
+	if err := ValidateWorkloadKinds(append(DefaultWorkloadKinds, WorkloadKindRollout)); err != nil {
+		t.Errorf("ValidateWorkloadKinds() unexpected error = %v", err)
+	}
+	if err := ValidateWorkloadKinds([]string{"ReplicaSet"}); err == nil {
+		t.Error("ValidateWorkloadKinds() expected error for ReplicaSet")
+	}
+}
+
+func TestRestartWorkloads(t *testing.T) {
+	s := scheme.Scheme
+	_ = appsv1.AddToScheme(s)
+	_ = batchv1.AddToScheme(s)
+
+	namespace := "default"
+	saName := "workload-identity-testapp"
+	meta := func(name string) metav1.ObjectMeta { return metav1.ObjectMeta{Name: name, Namespace: namespace} }
+
+	restarted := map[string]client.Object{
+		WorkloadKindDeployment:  &appsv1.Deployment{ObjectMeta: meta("deployment"), Spec: appsv1.DeploymentSpec{Template: podTemplate(saName)}},
+		WorkloadKindStatefulSet: &appsv1.StatefulSet{ObjectMeta: meta("statefulset"), Spec: appsv1.StatefulSetSpec{Template: podTemplate(saName)}},
+		WorkloadKindDaemonSet:   &appsv1.DaemonSet{ObjectMeta: meta("daemonset"), Spec: appsv1.DaemonSetSpec{Template: podTemplate(saName)}},
+		WorkloadKindCronJob: &batchv1.CronJob{ObjectMeta: meta("cronjob"), Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: podTemplate(saName)}},
+		}},
+		WorkloadKindRollout: rollout("rollout", namespace, saName),
+	}
+	unrelated := &appsv1.Deployment{ObjectMeta: meta("unrelated"), Spec: appsv1.DeploymentSpec{Template: podTemplate("default")}}
+
+	tests := []struct {
+		name  string
+		kinds []string
+		want  []string
+	}{
+		{name: "default kinds", kinds: nil, want: DefaultWorkloadKinds},
+		{name: "all kinds", kinds: append(DefaultWorkloadKinds, WorkloadKindRollout), want: append(DefaultWorkloadKinds, WorkloadKindRollout)},
+		{name: "deployments only", kinds: []string{WorkloadKindDeployment}, want: []string{WorkloadKindDeployment}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []client.Object{unrelated}
+			for _, obj := range restarted {
+				objs = append(objs, obj.DeepCopyObject().(client.Object))
+			}
+			cl := withIndexes(fake.NewClientBuilder()).WithScheme(s).WithObjects(objs...).Build()
+			r := &UserAssignedIdentityReconciler{Client: cl, Scheme: s, Log: zap.New(zap.UseDevMode(true)), WorkloadKinds: tt.kinds}
+
+			ctx := context.Background()
+			var result serviceAccountSync
+			if _, err := r.restartWorkloads(ctx, saName, namespace, time.Now(), nil, &result, r.Log); err != nil {
+				t.Fatalf("restartWorkloads() error = %v", err)
+			}
+			if len(result.RestartedWorkloads) != len(tt.want) {
+				t.Errorf("RestartedWorkloads incorrect. Expected %d, got %v", len(tt.want), result.RestartedWorkloads)
+			}
+
+			wanted := map[string]bool{}
+			for _, kind := range tt.want {
+				wanted[kind] = true
+			}
+			for kind, obj := range restarted {
+				got := workloadKinds[kind].object()
+				if err := cl.Get(ctx, client.ObjectKeyFromObject(obj), got); err != nil {
+					t.Fatalf("Failed to get %s: %v", kind, err)
+				}
+				if annotated := hasRestartAnnotation(t, kind, got); annotated != wanted[kind] {
+					t.Errorf("%s restart annotation incorrect. Expected %t, got %t", kind, wanted[kind], annotated)
+				}
+			}
+
+			var untouched appsv1.Deployment
+			if err := cl.Get(ctx, client.ObjectKeyFromObject(unrelated), &untouched); err != nil {
+				t.Fatalf("Failed to get Deployment: %v", err)
+			}
+			if _, ok := untouched.Spec.Template.Annotations[restartAnnotation]; ok {
+				t.Error("Deployment using another ServiceAccount was restarted")
+			}
+		})
+	}
+}
+
+func hasRestartAnnotation(t *testing.T, kind string, obj client.Object) bool {
+	t.Helper()
+	var annotations map[string]string
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		annotations = o.Spec.Template.Annotations
+	case *appsv1.StatefulSet:
+		annotations = o.Spec.Template.Annotations
+	case *appsv1.DaemonSet:
+		annotations = o.Spec.Template.Annotations
+	case *batchv1.CronJob:
+		annotations = o.Spec.JobTemplate.Spec.Template.Annotations
+	case *unstructured.Unstructured:
+		annotations, _, _ = unstructured.NestedStringMap(o.Object, "spec", "template", "metadata", "annotations")
+	default:
+		t.Fatalf("unexpected %s type %T", kind, obj)
+	}
+	_, ok := annotations[restartAnnotation]
+	return ok
+}